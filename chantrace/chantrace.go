@@ -0,0 +1,345 @@
+// Package chantrace records the channel operations of goroutines, so that
+// they can be drawn as a sequence diagram showing which goroutine unblocked
+// which.
+//
+// The operations are not intercepted: a program calls the Tracer right after
+// each operation it wants to see, for instance
+//
+//	ch <- v
+//	tr.Send(ch, v)
+//
+// All methods do nothing on a nil *Tracer, which makes tracing opt-in at no
+// cost.
+//
+// Values are sent and received in order on a channel, so the nth receive of
+// a channel is paired with its nth send, whatever order the sends were
+// recorded in relative to the receives. This requires the sends of a channel
+// to be recorded in the order they happen, and so do its receives: goroutines
+// sending on the same channel, or receiving from it, must take turns.
+package chantrace
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Kind is the kind of a recorded event.
+type Kind int
+
+const (
+	Start Kind = iota // a goroutine started
+	Send              // a value was sent on a channel
+	Recv              // a value was received from a channel
+	Close             // a channel was closed
+)
+
+// maxValueLen is the length above which values are cut in the diagrams.
+const maxValueLen = 20
+
+// event is a recorded operation.
+type event struct {
+	time      time.Time
+	kind      Kind
+	goroutine int // index in Tracer.goroutines
+	parent    int // for Start, the goroutine which started it or -1
+	ch        any
+	value     string
+}
+
+// Tracer records the events of the goroutines calling it. It is safe for
+// concurrent use.
+type Tracer struct {
+	now func() time.Time // replaced by tests
+
+	mu         sync.Mutex
+	start      time.Time
+	goroutines []string       // names, in order of appearance
+	ids        map[uint64]int // runtime goroutine ID to index in goroutines
+	names      map[string]int // number of goroutines with a given name
+	chans      map[any]string // channel names
+	links      map[any]any    // channel to the channel its values come from
+	events     []event
+}
+
+// New returns a tracer which names the calling goroutine name.
+func New(name string) *Tracer {
+	t := &Tracer{
+		now:   time.Now,
+		ids:   make(map[uint64]int),
+		names: make(map[string]int),
+		chans: make(map[any]string),
+		links: make(map[any]any),
+	}
+	t.start = t.now()
+	id, _ := goroutineIDs()
+	t.ids[id] = t.addGoroutine(name)
+	return t
+}
+
+// goroutineIDs returns the runtime ID of the calling goroutine and that of
+// the goroutine which started it, or 0 if unknown.
+func goroutineIDs() (id, parent uint64) {
+	buf := make([]byte, 4096)
+	for {
+		n := runtime.Stack(buf, false)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	// The stack starts with "goroutine 7 [running]:" and ends with a line
+	// like "created by main.f in goroutine 1" except for the main goroutine.
+	if f := bytes.Fields(buf); len(f) > 1 {
+		id, _ = strconv.ParseUint(string(f[1]), 10, 64)
+	}
+	if i := bytes.LastIndex(buf, []byte(" in goroutine ")); i >= 0 {
+		f := bytes.Fields(buf[i+len(" in goroutine "):])
+		if len(f) > 0 {
+			parent, _ = strconv.ParseUint(string(f[0]), 10, 64)
+		}
+	}
+	return id, parent
+}
+
+// addGoroutine adds a goroutine, numbering its name if it is already taken.
+func (t *Tracer) addGoroutine(name string) int {
+	t.names[name]++
+	if n := t.names[name]; n > 1 {
+		name = fmt.Sprintf("%s#%d", name, n)
+	}
+	t.goroutines = append(t.goroutines, name)
+	return len(t.goroutines) - 1
+}
+
+// current returns the index of the calling goroutine, adding it under its
+// runtime ID if it never called Start.
+func (t *Tracer) current(id uint64) int {
+	g, ok := t.ids[id]
+	if !ok {
+		g = t.addGoroutine("goroutine " + strconv.FormatUint(id, 10))
+		t.ids[id] = g
+	}
+	return g
+}
+
+// Start names the calling goroutine and records that it started. It should
+// be the first statement of the goroutine, and does nothing if the goroutine
+// already called it.
+func (t *Tracer) Start(name string) {
+	if t == nil {
+		return
+	}
+	id, parentID := goroutineIDs()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.ids[id]; ok {
+		return
+	}
+	parent, ok := t.ids[parentID]
+	if !ok {
+		parent = -1
+	}
+	g := t.addGoroutine(name)
+	t.ids[id] = g
+	t.events = append(t.events, event{time: t.now(), kind: Start, goroutine: g, parent: parent})
+}
+
+// Name names a channel. Channels which are not named are called chan1,
+// chan2... in order of appearance. Any comparable value can stand for a
+// channel, for instance one hidden inside a type; a string stands for the
+// channel of that name.
+func (t *Tracer) Name(ch any, name string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.chans[ch] = name
+}
+
+// Link records that the values sent on from are received from each of the
+// channels to, as when the channels are connected by pipeline.Tee.
+func (t *Tracer) Link(from any, to ...any) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, ch := range to {
+		t.links[ch] = from
+	}
+}
+
+// Send records that the calling goroutine sent v on ch.
+func (t *Tracer) Send(ch any, v any) {
+	t.record(Send, ch, v)
+}
+
+// Recv records that the calling goroutine received v from ch.
+func (t *Tracer) Recv(ch any, v any) {
+	t.record(Recv, ch, v)
+}
+
+// Close records that the calling goroutine closed ch.
+func (t *Tracer) Close(ch any) {
+	t.record(Close, ch, nil)
+}
+
+func (t *Tracer) record(kind Kind, ch any, v any) {
+	if t == nil {
+		return
+	}
+	id, _ := goroutineIDs()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.chans[ch]; !ok {
+		if name, ok := ch.(string); ok {
+			t.chans[ch] = name
+		} else {
+			t.chans[ch] = fmt.Sprintf("chan%d", len(t.chans)+1)
+		}
+	}
+	e := event{time: t.now(), kind: kind, goroutine: t.current(id), parent: -1, ch: ch}
+	if v != nil {
+		e.value = fmt.Sprint(v)
+		if len(e.value) > maxValueLen {
+			e.value = e.value[:maxValueLen-3] + "..."
+		}
+	}
+	t.events = append(t.events, e)
+}
+
+// trace is a copy of the recorded events with the sends and receives paired.
+type trace struct {
+	start      time.Time
+	goroutines []string
+	events     []event
+	chans      map[any]string
+	sendOf     []int   // for a receive, the index of the matching send or -1
+	recvsOf    [][]int // for a send, the indexes of the matching receives
+}
+
+// snapshot returns the events recorded so far.
+func (t *Tracer) snapshot() *trace {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tr := &trace{
+		start:      t.start,
+		goroutines: append([]string(nil), t.goroutines...),
+		events:     append([]event(nil), t.events...),
+		chans:      make(map[any]string, len(t.chans)),
+		sendOf:     make([]int, len(t.events)),
+		recvsOf:    make([][]int, len(t.events)),
+	}
+	for ch, name := range t.chans {
+		tr.chans[ch] = name
+	}
+
+	sends := make(map[any][]int)
+	for i, e := range tr.events {
+		tr.sendOf[i] = -1
+		if e.kind == Send {
+			sends[e.ch] = append(sends[e.ch], i)
+		}
+	}
+	// A channel linked to another receives all its values, so its receives
+	// are paired with the sends of the other one, not the other way around.
+	received := make(map[any]int)
+	for i, e := range tr.events {
+		if e.kind != Recv {
+			continue
+		}
+		from := e.ch
+		if src, ok := t.links[e.ch]; ok {
+			from = src
+		}
+		n := received[e.ch]
+		received[e.ch]++
+		if n < len(sends[from]) {
+			s := sends[from][n]
+			tr.sendOf[i] = s
+			tr.recvsOf[s] = append(tr.recvsOf[s], i)
+		}
+	}
+	return tr
+}
+
+// message names the channel of e along with the value, if any.
+func (tr *trace) message(e event) string {
+	if e.value == "" {
+		return tr.chans[e.ch]
+	}
+	return tr.chans[e.ch] + ": " + e.value
+}
+
+// label describes an event on its own.
+func (tr *trace) label(e event) string {
+	switch e.kind {
+	case Start:
+		return "start"
+	case Send:
+		return "send " + tr.message(e)
+	case Recv:
+		return "recv " + tr.message(e)
+	default:
+		return "close " + tr.chans[e.ch]
+	}
+}
+
+// row is a line of a sequence diagram: an arrow between two goroutines, or
+// a note on a single one when from == to.
+type row struct {
+	from, to int
+	label    string
+	start    bool // the arrow starts a goroutine
+}
+
+// rows lays out the trace as a sequence diagram. A paired send and receive
+// become one arrow, drawn where the later of the two was recorded. first
+// holds the first row of each goroutine, where its lifeline begins.
+func (tr *trace) rows() (rows []row, first []int) {
+	first = make([]int, len(tr.goroutines))
+	for g := range first {
+		first[g] = -1
+	}
+	first[0] = 0
+	add := func(r row) {
+		for _, g := range []int{r.from, r.to} {
+			if first[g] == -1 {
+				first[g] = len(rows)
+			}
+		}
+		rows = append(rows, r)
+	}
+	arrow := func(send, recv event) {
+		if send.goroutine == recv.goroutine {
+			add(row{from: recv.goroutine, to: recv.goroutine, label: tr.label(recv)})
+			return
+		}
+		add(row{from: send.goroutine, to: recv.goroutine, label: tr.message(recv)})
+	}
+	for i, e := range tr.events {
+		switch {
+		case e.kind == Start && e.parent >= 0:
+			add(row{from: e.parent, to: e.goroutine, label: "go", start: true})
+		case e.kind == Recv && tr.sendOf[i] >= 0:
+			if s := tr.sendOf[i]; s < i {
+				arrow(tr.events[s], e)
+			}
+		case e.kind == Send && len(tr.recvsOf[i]) > 0:
+			for _, r := range tr.recvsOf[i] {
+				if r < i {
+					arrow(e, tr.events[r])
+				}
+			}
+		default:
+			add(row{from: e.goroutine, to: e.goroutine, label: tr.label(e)})
+		}
+	}
+	return rows, first
+}
@@ -0,0 +1,201 @@
+package chantrace
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestTracer returns a tracer whose clock advances by 1ms on every event.
+func newTestTracer() *Tracer {
+	t := New("main")
+	t.start = time.Date(2020, time.February, 22, 9, 30, 0, 0, time.UTC)
+	now := t.start
+	t.now = func() time.Time {
+		now = now.Add(time.Millisecond)
+		return now
+	}
+	return t
+}
+
+// pingPong records a worker sending a value to main, which then closes the
+// channel.
+func pingPong(tr *Tracer) {
+	tr.mu.Lock()
+	recorded := len(tr.events)
+	tr.mu.Unlock()
+	ch := make(chan int)
+	tr.Name(ch, "ch")
+	go func() {
+		tr.Start("worker")
+		ch <- 3
+		tr.Send(ch, 3)
+	}()
+	v := <-ch
+	tr.Recv(ch, v)
+	// Wait for the worker to record its send.
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		tr.mu.Lock()
+		n := len(tr.events)
+		tr.mu.Unlock()
+		if n == recorded+3 {
+			break
+		}
+	}
+	close(ch)
+	tr.Close(ch)
+}
+
+func TestASCII(t *testing.T) {
+	tr := newTestTracer()
+	pingPong(tr)
+	var b strings.Builder
+	if err := tr.WriteASCII(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `main          worker
+|-----go----->|
+|<--ch: 3-----|
+| close ch    |
+`
+	if got := b.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestLink(t *testing.T) {
+	tr := newTestTracer()
+	x, a, out := make(chan int, 2), make(chan int, 2), make(chan int, 2)
+	tr.Name(x, "x")
+	tr.Name(a, "a")
+	tr.Name(out, "out")
+	tr.Link(x, a, out)
+	done := make(chan bool)
+	go func() {
+		tr.Start("gen")
+		for i := 1; i <= 2; i++ {
+			x <- i
+			tr.Send(x, i)
+			a <- i
+			out <- i
+			tr.Recv(a, <-a)
+		}
+		close(done)
+	}()
+	<-done
+	tr.Recv(out, <-out)
+	tr.Recv(out, <-out)
+
+	var b strings.Builder
+	tr.WriteASCII(&b)
+	want := `main           gen
+|-----go------>|
+|              | recv a: 1
+|              | recv a: 2
+|<--out: 1-----|
+|<--out: 2-----|
+`
+	if got := b.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestSVG(t *testing.T) {
+	tr := newTestTracer()
+	tr.Name(tr, "<tracer>") // needs escaping
+	tr.Send(tr, "a & b")
+	pingPong(tr)
+	var b strings.Builder
+	if err := tr.WriteSVG(&b); err != nil {
+		t.Fatal(err)
+	}
+	var lines, texts int
+	dec := xml.NewDecoder(strings.NewReader(b.String()))
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("invalid SVG: %v\n%s", err, b.String())
+		}
+		if el, ok := tok.(xml.StartElement); ok {
+			switch el.Name.Local {
+			case "line":
+				lines++
+			case "text":
+				texts++
+			}
+		}
+	}
+	// Two lifelines, two arrows and two notes with their label.
+	if lines != 4 || texts != 6 {
+		t.Errorf("got %d lines and %d texts:\n%s", lines, texts, b.String())
+	}
+	if !strings.Contains(b.String(), "send &lt;tracer&gt;: a &amp; b") {
+		t.Errorf("labels are not escaped:\n%s", b.String())
+	}
+}
+
+func TestChrome(t *testing.T) {
+	tr := newTestTracer()
+	pingPong(tr)
+	var b strings.Builder
+	if err := tr.WriteChrome(&b); err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		TraceEvents []chromeEvent
+	}
+	if err := json.Unmarshal([]byte(b.String()), &got); err != nil {
+		t.Fatal(err)
+	}
+	count := make(map[string]int)
+	for _, e := range got.TraceEvents {
+		count[e.Ph]++
+		if e.Ph == "X" && e.Ts <= 0 {
+			t.Errorf("event %+v has no time", e)
+		}
+	}
+	// Two thread names; the start, the go statement, the send, the receive
+	// and the close; a flow for the start and one for the message.
+	if count["M"] != 2 || count["X"] != 5 || count["s"] != 2 || count["f"] != 2 {
+		t.Errorf("got events %v:\n%s", count, b.String())
+	}
+}
+
+func TestNil(t *testing.T) {
+	var tr *Tracer
+	tr.Start("nobody")
+	tr.Name(tr, "nothing")
+	tr.Link(1, 2)
+	tr.Send(1, 2)
+	tr.Recv(1, 2)
+	tr.Close(1)
+}
+
+func TestNames(t *testing.T) {
+	tr := newTestTracer()
+	done := make(chan bool)
+	for i := 0; i < 2; i++ {
+		go func() {
+			tr.Start("worker")
+			tr.Start("ignored")
+			done <- true
+		}()
+		<-done
+	}
+	go func() {
+		tr.Send(done, nil) // never started
+		done <- true
+	}()
+	<-done
+	if got := strings.Join(tr.goroutines, ","); !strings.HasPrefix(got, "main,worker,worker#2,goroutine ") {
+		t.Errorf("got goroutines %s", got)
+	}
+	if name := tr.chans[done]; name != "chan1" {
+		t.Errorf("got channel name %q", name)
+	}
+}
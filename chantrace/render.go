@@ -0,0 +1,183 @@
+package chantrace
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+)
+
+// columnWidth returns the distance between lifelines needed to fit the names
+// of the goroutines and the labels of the rows.
+func columnWidth(names []string, rows []row) int {
+	w := 12
+	for _, name := range names {
+		w = max(w, len(name)+2)
+	}
+	for _, r := range rows {
+		w = max(w, len(r.label)+6)
+	}
+	return w
+}
+
+// WriteASCII writes the events recorded so far as a text sequence diagram
+// with a column for each goroutine, such as:
+//
+//	main          Tea
+//	|-----go----->|
+//	|<--ch: 3-----|
+//	| recv x: 1   |
+func (t *Tracer) WriteASCII(w io.Writer) error {
+	tr := t.snapshot()
+	rows, first := tr.rows()
+	width := columnWidth(tr.goroutines, rows)
+	line := make([]byte, width*(len(tr.goroutines)+1)) // notes may overflow the last column
+
+	bw := bufio.NewWriter(w)
+	writeLine := func() {
+		bw.WriteString(strings.TrimRight(string(line), " "))
+		bw.WriteByte('\n')
+	}
+	for i := range line {
+		line[i] = ' '
+	}
+	for g, name := range tr.goroutines {
+		copy(line[g*width:], name)
+	}
+	writeLine()
+	for i, r := range rows {
+		for j := range line {
+			line[j] = ' '
+		}
+		for g := range tr.goroutines {
+			if first[g] != -1 && first[g] <= i {
+				line[g*width] = '|'
+			}
+		}
+		if r.from == r.to {
+			copy(line[r.from*width+2:], r.label)
+			writeLine()
+			continue
+		}
+		left, right := min(r.from, r.to)*width, max(r.from, r.to)*width
+		for j := left + 1; j < right; j++ {
+			line[j] = '-'
+		}
+		if r.from < r.to {
+			line[right-1] = '>'
+		} else {
+			line[left+1] = '<'
+		}
+		copy(line[left+(right-left-len(r.label))/2:], r.label)
+		writeLine()
+	}
+	return bw.Flush()
+}
+
+// The dimensions of the SVG diagram, in pixels.
+const (
+	svgCharWidth = 7
+	svgRowHeight = 24
+	svgMargin    = 20
+)
+
+// WriteSVG writes the events recorded so far as an SVG sequence diagram.
+func (t *Tracer) WriteSVG(w io.Writer) error {
+	tr := t.snapshot()
+	rows, first := tr.rows()
+	col := columnWidth(tr.goroutines, rows) * svgCharWidth
+	x := func(g int) int { return svgMargin + col/2 + g*col }
+	y := func(i int) int { return svgMargin + (i+2)*svgRowHeight }
+	width := 2*svgMargin + col*len(tr.goroutines)
+	height := y(len(rows)) + svgMargin
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="monospace" font-size="12">`+"\n", width, height)
+	bw.WriteString(`<defs><marker id="arrow" viewBox="0 0 10 10" refX="10" refY="5" markerWidth="8" markerHeight="8" orient="auto"><path d="M0,0L10,5L0,10z"/></marker></defs>` + "\n")
+	for g, name := range tr.goroutines {
+		top := y(first[g]) - svgRowHeight/2
+		if g == 0 {
+			top = y(-1)
+		}
+		fmt.Fprintf(bw, `<text x="%d" y="%d" text-anchor="middle" font-weight="bold">%s</text>`+"\n", x(g), top-4, html.EscapeString(name))
+		fmt.Fprintf(bw, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="gray"/>`+"\n", x(g), top, x(g), height-svgMargin)
+	}
+	for i, r := range rows {
+		label := html.EscapeString(r.label)
+		if r.from == r.to {
+			fmt.Fprintf(bw, `<circle cx="%d" cy="%d" r="3"/>`+"\n", x(r.from), y(i))
+			fmt.Fprintf(bw, `<text x="%d" y="%d">%s</text>`+"\n", x(r.from)+8, y(i)+4, label)
+			continue
+		}
+		dash := ""
+		if r.start {
+			dash = ` stroke-dasharray="4,3"`
+		}
+		fmt.Fprintf(bw, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="black"%s marker-end="url(#arrow)"/>`+"\n", x(r.from), y(i), x(r.to), y(i), dash)
+		fmt.Fprintf(bw, `<text x="%d" y="%d" text-anchor="middle">%s</text>`+"\n", (x(r.from)+x(r.to))/2, y(i)-4, label)
+	}
+	bw.WriteString("</svg>\n")
+	return bw.Flush()
+}
+
+// chromeEvent is an event of the Chrome trace event format, which can be
+// loaded in chrome://tracing or https://ui.perfetto.dev. Goroutines are shown
+// as threads and the pairs of operations as flow arrows.
+type chromeEvent struct {
+	Name string            `json:"name"`
+	Cat  string            `json:"cat,omitempty"`
+	Ph   string            `json:"ph"`
+	Ts   float64           `json:"ts"` // microseconds
+	Dur  float64           `json:"dur,omitempty"`
+	Pid  int               `json:"pid"`
+	Tid  int               `json:"tid"`
+	ID   int               `json:"id,omitempty"`
+	BP   string            `json:"bp,omitempty"`
+	Args map[string]string `json:"args,omitempty"`
+}
+
+// WriteChrome writes the events recorded so far in the Chrome trace event
+// format.
+func (t *Tracer) WriteChrome(w io.Writer) error {
+	tr := t.snapshot()
+	var events []chromeEvent
+	for g, name := range tr.goroutines {
+		events = append(events, chromeEvent{Name: "thread_name", Ph: "M", Pid: 1, Tid: g, Args: map[string]string{"name": name}})
+	}
+	ts := func(e event) float64 { return float64(e.time.Sub(tr.start).Nanoseconds()) / 1000 }
+	// Flow events attach to the slice enclosing them, so every operation is
+	// a slice of 1µs.
+	flow := 0
+	for i, e := range tr.events {
+		slice := chromeEvent{Name: tr.label(e), Cat: "chan", Ph: "X", Ts: ts(e), Dur: 1, Pid: 1, Tid: e.goroutine}
+		if e.kind == Start {
+			slice.Cat = "goroutine"
+		}
+		events = append(events, slice)
+		switch {
+		case e.kind == Start && e.parent >= 0:
+			// The start is recorded by the goroutine itself, after the go
+			// statement: the arrow begins where the parent is at that time.
+			flow++
+			events = append(events,
+				chromeEvent{Name: "go " + tr.goroutines[e.goroutine], Cat: "goroutine", Ph: "X", Ts: ts(e), Dur: 1, Pid: 1, Tid: e.parent},
+				chromeEvent{Name: "go", Cat: "goroutine", Ph: "s", Ts: ts(e), Pid: 1, Tid: e.parent, ID: flow},
+				chromeEvent{Name: "go", Cat: "goroutine", Ph: "f", BP: "e", Ts: ts(e), Pid: 1, Tid: e.goroutine, ID: flow})
+		case e.kind == Send:
+			for _, r := range tr.recvsOf[i] {
+				recv := tr.events[r]
+				flow++
+				name := tr.chans[recv.ch]
+				events = append(events,
+					chromeEvent{Name: name, Cat: "chan", Ph: "s", Ts: ts(e), Pid: 1, Tid: e.goroutine, ID: flow},
+					chromeEvent{Name: name, Cat: "chan", Ph: "f", BP: "e", Ts: ts(recv), Pid: 1, Tid: recv.goroutine, ID: flow})
+			}
+		}
+	}
+	return json.NewEncoder(w).Encode(struct {
+		TraceEvents     []chromeEvent `json:"traceEvents"`
+		DisplayTimeUnit string        `json:"displayTimeUnit"`
+	}{events, "ms"})
+}
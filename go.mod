@@ -0,0 +1,3 @@
+module github.com/fakhir/learngo
+
+go 1.22
@@ -0,0 +1,67 @@
+// Package leaktest checks that tests leave no goroutines running.
+package leaktest
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fakhir/learngo/internal/stacks"
+)
+
+// Timeout is how long goroutines may take to exit after a test ends, for
+// instance those stopped by a cancelled context.
+const Timeout = 2 * time.Second
+
+// ignore lists functions of goroutines which the standard library starts
+// once and keeps running for the lifetime of the process.
+var ignore = []string{
+	"os/signal.loop", // started by the first signal.Notify
+}
+
+// Goroutines returns the stacks of all goroutines by goroutine ID.
+func Goroutines() map[int]string {
+	all := make(map[int]string)
+	for _, g := range stacks.All() {
+		all[g.ID] = g.Text
+	}
+	return all
+}
+
+// Leaked waits up to timeout for the goroutines which are not in before to
+// exit, and returns the stacks of those which are still running.
+func Leaked(before map[int]string, timeout time.Duration) []string {
+	deadline := time.Now().Add(timeout)
+	for {
+		var leaked []string
+	next:
+		for id, stack := range Goroutines() {
+			if _, ok := before[id]; ok {
+				continue
+			}
+			for _, fn := range ignore {
+				if strings.Contains(stack, fn) {
+					continue next
+				}
+			}
+			leaked = append(leaked, stack)
+		}
+		if len(leaked) == 0 || time.Now().After(deadline) {
+			return leaked
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Check fails the test with their stacks if goroutines started by it are
+// still running Timeout after it ends. Tests calling it must not run in
+// parallel.
+func Check(t testing.TB) {
+	t.Helper()
+	before := Goroutines()
+	t.Cleanup(func() {
+		if leaked := Leaked(before, Timeout); len(leaked) > 0 {
+			t.Errorf("%d goroutines leaked:\n\n%s", len(leaked), strings.Join(leaked, "\n\n"))
+		}
+	})
+}
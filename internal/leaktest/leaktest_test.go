@@ -0,0 +1,21 @@
+package leaktest
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLeaked(t *testing.T) {
+	before := Goroutines()
+	release := make(chan bool)
+	go func() { <-release }()
+	leaked := Leaked(before, 10*time.Millisecond)
+	if len(leaked) != 1 || !strings.Contains(leaked[0], "TestLeaked") {
+		t.Errorf("got leaked goroutines %q, want the blocked one", leaked)
+	}
+	close(release)
+	if leaked := Leaked(before, Timeout); len(leaked) > 0 {
+		t.Errorf("goroutine still reported after it exited: %q", leaked)
+	}
+}
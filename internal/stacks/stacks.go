@@ -0,0 +1,83 @@
+// Package stacks parses the stacks of goroutines printed by runtime.Stack, for
+// the programs and tests which watch their own goroutines.
+package stacks
+
+import (
+	"bufio"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Goroutine is the stack of a goroutine, as printed by runtime.Stack.
+type Goroutine struct {
+	ID     int
+	State  string // e.g. "chan receive" or "sync.Mutex.Lock"
+	Frames []Frame
+	Text   string
+}
+
+// Frame is a function call of a stack.
+type Frame struct {
+	Function string // e.g. "main.deadlockLockTwice"
+	File     string
+	Line     int
+	Created  bool // the go statement which started the goroutine
+}
+
+// All returns the stacks of all goroutines, starting with the calling one.
+func All() []Goroutine {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return Parse(buf[:n])
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// Parse parses the output of runtime.Stack or debug.Stack, which looks like:
+//
+//	goroutine 7 [chan receive, 2 minutes]:
+//	main.deadlockReadTooMany(0xc000010018)
+//		/src/refresher.go:123 +0x45
+//	created by main.runWatched in goroutine 1
+//		/src/refresher_watchdog.go:45 +0x10b
+func Parse(buf []byte) []Goroutine {
+	var stacks []Goroutine
+	for _, text := range strings.Split(strings.TrimSpace(string(buf)), "\n\n") {
+		sc := bufio.NewScanner(strings.NewReader(text))
+		if !sc.Scan() {
+			continue
+		}
+		var g Goroutine
+		header, ok := strings.CutPrefix(sc.Text(), "goroutine ")
+		if !ok {
+			continue
+		}
+		id, state, _ := strings.Cut(header, " ")
+		g.ID, _ = strconv.Atoi(id)
+		state = strings.TrimSuffix(strings.TrimPrefix(state, "["), "]:")
+		g.State, _, _ = strings.Cut(state, ",") // drop the waiting time
+		g.Text = text
+		for sc.Scan() {
+			function := sc.Text()
+			if !sc.Scan() {
+				break
+			}
+			function, created := strings.CutPrefix(function, "created by ")
+			if created {
+				function, _, _ = strings.Cut(function, " in goroutine ")
+			} else if i := strings.LastIndexByte(function, '('); i > 0 {
+				function = function[:i]
+			}
+			location, _, _ := strings.Cut(strings.TrimSpace(sc.Text()), " +")
+			file, line, _ := strings.Cut(location, ":")
+			n, _ := strconv.Atoi(line)
+			g.Frames = append(g.Frames, Frame{function, file, n, created})
+		}
+		stacks = append(stacks, g)
+	}
+	return stacks
+}
@@ -0,0 +1,44 @@
+package stacks
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	stacks := Parse([]byte(`goroutine 7 [chan receive, 2 minutes]:
+main.deadlockReadTooMany(0xc000010018)
+	/src/refresher.go:3 +0x45
+created by main.runWatched in goroutine 1
+	/src/refresher_watchdog.go:45 +0x10b
+
+goroutine 9 [select]:
+main.moreOnChannels.func2()
+	/src/pipeline/pipeline.go:30 +0x45
+created by main.moreOnChannels in goroutine 7
+	/src/refresher.go:2 +0x10b
+`))
+	if len(stacks) != 2 {
+		t.Fatalf("got %d stacks", len(stacks))
+	}
+	g := stacks[0]
+	if g.ID != 7 || g.State != "chan receive" || len(g.Frames) != 2 {
+		t.Errorf("got %+v", g)
+	}
+	if f := g.Frames[0]; f.Function != "main.deadlockReadTooMany" || f.File != "/src/refresher.go" || f.Line != 3 || f.Created {
+		t.Errorf("got frame %+v", f)
+	}
+	if f := g.Frames[1]; f.Function != "main.runWatched" || !f.Created {
+		t.Errorf("got frame %+v", f)
+	}
+	if g := stacks[1]; g.ID != 9 || g.State != "select" || !strings.HasPrefix(g.Text, "goroutine 9 [select]:\n") {
+		t.Errorf("got %+v", g)
+	}
+}
+
+func TestAll(t *testing.T) {
+	stacks := All()
+	if len(stacks) == 0 || stacks[0].State != "running" || !strings.Contains(stacks[0].Text, "TestAll") {
+		t.Errorf("the calling goroutine does not come first: %+v", stacks)
+	}
+}
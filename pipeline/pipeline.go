@@ -0,0 +1,244 @@
+// Package pipeline provides generic stages which connect goroutines with
+// channels, generalizing dup3 of the moreOnChannels section.
+//
+// Every stage runs in its own goroutines, reads from its input channels until
+// they are closed and closes its output channels when it is done.
+//
+// Cancellation: every send and receive of a stage also waits on its context.
+// Once the context is cancelled the stage stops and closes its outputs, even
+// if its input is not closed or nobody reads its outputs. A consumer which
+// stops reading before the end, as Take does, must therefore cancel the
+// context to release the stages feeding it, or they block forever.
+//
+// Backpressure: the outputs are unbuffered, except for Buffer, and a stage
+// reads its next input only after the previous value has been sent. A slow
+// consumer thus slows down every stage before it. Tee proceeds at the pace of
+// its slowest output, FanOut at the pace of its fastest one.
+package pipeline
+
+import (
+	"context"
+	"reflect"
+	"sync"
+)
+
+// send sends v on out unless ctx is done first. It reports whether v was sent.
+func send[T any](ctx context.Context, out chan<- T, v T) bool {
+	select {
+	case out <- v:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// receive receives a value from in unless ctx is done first. The boolean is
+// false if in is closed or ctx is done.
+func receive[T any](ctx context.Context, in <-chan T) (T, bool) {
+	select {
+	case v, ok := <-in:
+		return v, ok
+	case <-ctx.Done():
+		var zero T
+		return zero, false
+	}
+}
+
+// From returns a channel on which the values are sent.
+func From[T any](ctx context.Context, values ...T) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for _, v := range values {
+			if !send(ctx, out, v) {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Tee copies every value of in to n outputs. The next value is read only when
+// the current one has been received from all outputs, in any order.
+func Tee[T any](ctx context.Context, in <-chan T, n int) []<-chan T {
+	outs := make([]chan T, n)
+	result := make([]<-chan T, n)
+	for i := range outs {
+		outs[i] = make(chan T)
+		result[i] = outs[i]
+	}
+	go func() {
+		defer func() {
+			for _, out := range outs {
+				close(out)
+			}
+		}()
+		// The first case waits for ctx, the others send to the outputs which
+		// have not received the value yet.
+		cases := make([]reflect.SelectCase, n+1)
+		cases[0] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())}
+		for {
+			v, ok := receive(ctx, in)
+			if !ok {
+				return
+			}
+			for i, out := range outs {
+				cases[i+1] = reflect.SelectCase{Dir: reflect.SelectSend, Chan: reflect.ValueOf(out), Send: reflect.ValueOf(v)}
+			}
+			for pending := n; pending > 0; pending-- {
+				chosen, _, _ := reflect.Select(cases)
+				if chosen == 0 {
+					return
+				}
+				cases[chosen].Chan = reflect.Value{} // a zero Chan is never ready
+			}
+		}
+	}()
+	return result
+}
+
+// Merge sends the values of all inputs to a single output, in the order they
+// arrive. The output is closed once all inputs are closed.
+func Merge[T any](ctx context.Context, ins ...<-chan T) <-chan T {
+	out := make(chan T)
+	var wg sync.WaitGroup
+	wg.Add(len(ins))
+	for _, in := range ins {
+		go func(in <-chan T) {
+			defer wg.Done()
+			for {
+				v, ok := receive(ctx, in)
+				if !ok || !send(ctx, out, v) {
+					return
+				}
+			}
+		}(in)
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+// FanOut distributes the values of in to n outputs: each value is sent to
+// only one of them, whichever is ready first. This lets n workers share the
+// values, with FanIn to collect their results.
+func FanOut[T any](ctx context.Context, in <-chan T, n int) []<-chan T {
+	result := make([]<-chan T, n)
+	for i := range result {
+		out := make(chan T)
+		result[i] = out
+		go func() {
+			defer close(out)
+			for {
+				v, ok := receive(ctx, in)
+				if !ok || !send(ctx, out, v) {
+					return
+				}
+			}
+		}()
+	}
+	return result
+}
+
+// FanIn is Merge, named after its role in the fan-out/fan-in pattern.
+func FanIn[T any](ctx context.Context, ins ...<-chan T) <-chan T {
+	return Merge(ctx, ins...)
+}
+
+// Map sends f(v) for every value v of in.
+func Map[T, U any](ctx context.Context, in <-chan T, f func(T) U) <-chan U {
+	out := make(chan U)
+	go func() {
+		defer close(out)
+		for {
+			v, ok := receive(ctx, in)
+			if !ok || !send(ctx, out, f(v)) {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Filter sends the values of in for which keep returns true.
+func Filter[T any](ctx context.Context, in <-chan T, keep func(T) bool) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for {
+			v, ok := receive(ctx, in)
+			if !ok {
+				return
+			}
+			if keep(v) && !send(ctx, out, v) {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Batch groups the values of in into slices of size values. The last slice is
+// shorter if in is closed in the middle of a batch. It panics if size is not
+// positive.
+func Batch[T any](ctx context.Context, in <-chan T, size int) <-chan []T {
+	if size <= 0 {
+		panic("pipeline: Batch size must be positive")
+	}
+	out := make(chan []T)
+	go func() {
+		defer close(out)
+		var batch []T
+		for {
+			v, ok := receive(ctx, in)
+			if !ok {
+				if len(batch) > 0 && ctx.Err() == nil {
+					send(ctx, out, batch)
+				}
+				return
+			}
+			batch = append(batch, v)
+			if len(batch) == size {
+				if !send(ctx, out, batch) {
+					return
+				}
+				batch = nil
+			}
+		}
+	}()
+	return out
+}
+
+// Take sends the first n values of in and then closes its output. It stops
+// reading in, so the context must be cancelled to release the stages before.
+func Take[T any](ctx context.Context, in <-chan T, n int) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for i := 0; i < n; i++ {
+			v, ok := receive(ctx, in)
+			if !ok || !send(ctx, out, v) {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Buffer lets up to size values of in wait for the consumer of its output,
+// so that the producer does not wait for each of them to be received.
+func Buffer[T any](ctx context.Context, in <-chan T, size int) <-chan T {
+	out := make(chan T, size)
+	go func() {
+		defer close(out)
+		for {
+			v, ok := receive(ctx, in)
+			if !ok || !send(ctx, out, v) {
+				return
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,171 @@
+package pipeline
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fakhir/learngo/internal/leaktest"
+)
+
+func collect[T any](in <-chan T) []T {
+	var values []T
+	for v := range in {
+		values = append(values, v)
+	}
+	return values
+}
+
+// naturals sends 0, 1, 2, ... until ctx is cancelled.
+func naturals(ctx context.Context) <-chan int {
+	out := make(chan int)
+	go func() {
+		defer close(out)
+		for i := 0; send(ctx, out, i); i++ {
+		}
+	}()
+	return out
+}
+
+func TestStages(t *testing.T) {
+	leaktest.Check(t)
+	ctx := context.Background()
+	in := From(ctx, 1, 2, 3, 4, 5, 6, 7)
+	odd := Filter(ctx, in, func(n int) bool { return n%2 == 1 })
+	squares := Map(ctx, odd, func(n int) int { return n * n })
+	got := collect(Batch(ctx, squares, 3))
+	if want := [][]int{{1, 9, 25}, {49}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestBatchSize(t *testing.T) {
+	for _, size := range []int{0, -1} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Batch with size %d did not panic", size)
+				}
+			}()
+			Batch[int](context.Background(), nil, size)
+		}()
+	}
+}
+
+func TestTee(t *testing.T) {
+	leaktest.Check(t)
+	ctx := context.Background()
+	outs := Tee(ctx, From(ctx, 1, 2, 3), 3)
+	got := make([][]int, len(outs))
+	var wg sync.WaitGroup
+	for i, out := range outs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got[i] = collect(out)
+		}()
+	}
+	wg.Wait()
+	for i := range got {
+		if want := []int{1, 2, 3}; !reflect.DeepEqual(got[i], want) {
+			t.Errorf("output %d: got %v, want %v", i, got[i], want)
+		}
+	}
+}
+
+func TestTeeAnyOrder(t *testing.T) {
+	leaktest.Check(t)
+	ctx := context.Background()
+	outs := Tee(ctx, From(ctx, 1, 2), 2)
+	// Reading the second output first must not block Tee.
+	if v := <-outs[1]; v != 1 {
+		t.Errorf("got %d from the second output, want 1", v)
+	}
+	if v := <-outs[0]; v != 1 {
+		t.Errorf("got %d from the first output, want 1", v)
+	}
+	collect(Merge(ctx, outs...))
+}
+
+func TestFanOutFanIn(t *testing.T) {
+	leaktest.Check(t)
+	ctx := context.Background()
+	var workers []<-chan int
+	for _, in := range FanOut(ctx, From(ctx, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10), 4) {
+		workers = append(workers, Map(ctx, in, func(n int) int { return 10 * n }))
+	}
+	got := collect(FanIn(ctx, workers...))
+	sort.Ints(got)
+	if want := []int{10, 20, 30, 40, 50, 60, 70, 80, 90, 100}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestBuffer(t *testing.T) {
+	leaktest.Check(t)
+	ctx := context.Background()
+	in := make(chan int)
+	out := Buffer(ctx, in, 2)
+	// The producer is not blocked until the buffer and the stage are full.
+	for i := 0; i < 3; i++ {
+		select {
+		case in <- i:
+		case <-time.After(time.Second):
+			t.Fatalf("send %d blocked", i)
+		}
+	}
+	close(in)
+	if got, want := collect(out), []int{0, 1, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// TestCancel stops consuming every stage early and checks that cancelling
+// the context releases all goroutines, including those blocked on sends.
+func TestCancel(t *testing.T) {
+	stages := map[string]func(ctx context.Context, in <-chan int) <-chan int{
+		"Take": func(ctx context.Context, in <-chan int) <-chan int { return Take(ctx, in, 3) },
+		"Map": func(ctx context.Context, in <-chan int) <-chan int {
+			return Map(ctx, in, func(n int) int { return n + 1 })
+		},
+		"Filter": func(ctx context.Context, in <-chan int) <-chan int {
+			return Filter(ctx, in, func(n int) bool { return n%3 == 0 })
+		},
+		"Batch": func(ctx context.Context, in <-chan int) <-chan int {
+			return Map(ctx, Batch(ctx, in, 2), func(b []int) int { return b[0] })
+		},
+		"Tee": func(ctx context.Context, in <-chan int) <-chan int {
+			outs := Tee(ctx, in, 3)
+			return outs[1] // nobody reads the other outputs
+		},
+		"FanOut": func(ctx context.Context, in <-chan int) <-chan int { return FanOut(ctx, in, 3)[0] },
+		"Merge": func(ctx context.Context, in <-chan int) <-chan int {
+			return Merge(ctx, in, naturals(ctx))
+		},
+		"Buffer": func(ctx context.Context, in <-chan int) <-chan int { return Buffer(ctx, in, 4) },
+	}
+	for name, stage := range stages {
+		t.Run(name, func(t *testing.T) {
+			leaktest.Check(t)
+			ctx, cancel := context.WithCancel(context.Background())
+			out := stage(ctx, naturals(ctx))
+			<-out
+			cancel()
+			// The outputs are closed after the cancellation, possibly after
+			// sending values the stage was about to send.
+			for range out {
+			}
+		})
+	}
+}
+
+func TestCancelWithoutReading(t *testing.T) {
+	leaktest.Check(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	Map(ctx, Tee(ctx, naturals(ctx), 2)[0], func(n int) int { return n })
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+}
@@ -0,0 +1,405 @@
+// Package qr encodes data as QR codes, following ISO/IEC 18004, and renders
+// them as PNG or SVG images.
+//
+// Only the byte mode is implemented since it can hold any data. All four error
+// correction levels and all versions (sizes) from 1 to 40 are supported; the
+// smallest version which fits the data is chosen.
+//
+// The structure of this package follows the QR Code generator library of
+// Project Nayuki (https://www.nayuki.io/page/qr-code-generator-library).
+package qr
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Level is an error correction level. Higher levels make a code readable even
+// if more of it is damaged, at the price of a lower capacity.
+type Level int
+
+const (
+	L Level = iota // recovers about 7% of the codewords
+	M              // about 15%
+	Q              // about 25%
+	H              // about 30%
+)
+
+func (l Level) String() string {
+	if l < L || l > H {
+		return fmt.Sprintf("Level(%d)", int(l))
+	}
+	return "LMQH"[l : l+1]
+}
+
+// ParseLevel returns the level named by s, one of "L", "M", "Q" or "H".
+func ParseLevel(s string) (Level, error) {
+	for l := L; l <= H; l++ {
+		if s == l.String() {
+			return l, nil
+		}
+	}
+	return 0, fmt.Errorf("qr: invalid error correction level %q", s)
+}
+
+// ErrTooLong is returned by Encode if the data does not fit a version 40 code.
+var ErrTooLong = errors.New("qr: data too long")
+
+// Code is an encoded QR code: a square grid of dark and light modules.
+type Code struct {
+	Version int   // 1 to 40
+	Level   Level // error correction level
+	Mask    int   // mask pattern, 0 to 7
+	Size    int   // number of modules per side, 17 + 4*Version
+
+	dark     []bool // the modules, row by row
+	function []bool // the modules of function patterns, only while encoding
+}
+
+// Black reports whether the module at column x and row y is dark. Modules
+// outside of the code, such as the quiet zone around it, are light.
+func (c *Code) Black(x, y int) bool {
+	return 0 <= x && x < c.Size && 0 <= y && y < c.Size && c.dark[y*c.Size+x]
+}
+
+// Encode returns the smallest QR code holding data at the error correction
+// level.
+func Encode(data []byte, level Level) (*Code, error) {
+	if level < L || level > H {
+		return nil, fmt.Errorf("qr: invalid error correction level %d", int(level))
+	}
+	for version := minVersion; version <= maxVersion; version++ {
+		capacity := numDataCodewords(version, level) * 8
+		if 4+charCountBits(version)+8*len(data) <= capacity {
+			return encode(data, version, level), nil
+		}
+	}
+	return nil, ErrTooLong
+}
+
+// encode builds the code for data, which must fit in the version.
+func encode(data []byte, version int, level Level) *Code {
+	// The segment: byte mode indicator, the character count and the data.
+	var bits bitBuffer
+	bits.append(0x4, 4)
+	bits.append(len(data), charCountBits(version))
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+
+	// Terminator, padding to a whole byte and then the alternating pad bytes.
+	capacity := numDataCodewords(version, level) * 8
+	bits.append(0, min(4, capacity-bits.len()))
+	bits.append(0, (8-bits.len()%8)%8)
+	for pad := 0xEC; bits.len() < capacity; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+
+	size := version*4 + 17
+	c := &Code{
+		Version:  version,
+		Level:    level,
+		Size:     size,
+		dark:     make([]bool, size*size),
+		function: make([]bool, size*size),
+	}
+	c.drawFunctionPatterns()
+	c.drawCodewords(addECCAndInterleave(bits.bytes(), version, level))
+
+	// Use the mask which makes the code easiest to read.
+	minPenalty := -1
+	for mask := 0; mask < 8; mask++ {
+		c.applyMask(mask)
+		c.drawFormatBits(mask)
+		if penalty := c.penalty(); minPenalty < 0 || penalty < minPenalty {
+			c.Mask, minPenalty = mask, penalty
+		}
+		c.applyMask(mask) // XOR again to undo it
+	}
+	c.applyMask(c.Mask)
+	c.drawFormatBits(c.Mask)
+	c.function = nil
+	return c
+}
+
+func (c *Code) setFunction(x, y int, dark bool) {
+	c.dark[y*c.Size+x] = dark
+	c.function[y*c.Size+x] = true
+}
+
+// drawFunctionPatterns draws the patterns which help readers to locate and
+// decode the code, reserving the area of the format bits.
+func (c *Code) drawFunctionPatterns() {
+	for i := 0; i < c.Size; i++ {
+		c.setFunction(6, i, i%2 == 0)
+		c.setFunction(i, 6, i%2 == 0)
+	}
+
+	c.drawFinderPattern(3, 3)
+	c.drawFinderPattern(c.Size-4, 3)
+	c.drawFinderPattern(3, c.Size-4)
+
+	positions := alignmentPatternPositions(c.Version)
+	n := len(positions)
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			// Except where they would overlap the finder patterns.
+			if i == 0 && j == 0 || i == 0 && j == n-1 || i == n-1 && j == 0 {
+				continue
+			}
+			c.drawAlignmentPattern(positions[i], positions[j])
+		}
+	}
+
+	c.drawFormatBits(0)
+	c.drawVersion()
+}
+
+// drawFinderPattern draws a finder pattern and its separator centered at x, y.
+func (c *Code) drawFinderPattern(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if 0 <= xx && xx < c.Size && 0 <= yy && yy < c.Size {
+				dist := max(abs(dx), abs(dy))
+				c.setFunction(xx, yy, dist != 2 && dist != 4)
+			}
+		}
+	}
+}
+
+// drawAlignmentPattern draws an alignment pattern centered at x, y.
+func (c *Code) drawAlignmentPattern(x, y int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			c.setFunction(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+		}
+	}
+}
+
+// drawFormatBits draws both copies of the error correction level and mask,
+// protected by a BCH code, and the dark module next to them.
+func (c *Code) drawFormatBits(mask int) {
+	bits := formatBits(c.Level, mask)
+
+	// Around the top left finder pattern.
+	for i := 0; i <= 5; i++ {
+		c.setFunction(8, i, bit(bits, i))
+	}
+	c.setFunction(8, 7, bit(bits, 6))
+	c.setFunction(8, 8, bit(bits, 7))
+	c.setFunction(7, 8, bit(bits, 8))
+	for i := 9; i < 15; i++ {
+		c.setFunction(14-i, 8, bit(bits, i))
+	}
+
+	// Split between the other two finder patterns.
+	for i := 0; i < 8; i++ {
+		c.setFunction(c.Size-1-i, 8, bit(bits, i))
+	}
+	for i := 8; i < 15; i++ {
+		c.setFunction(8, c.Size-15+i, bit(bits, i))
+	}
+	c.setFunction(8, c.Size-8, true)
+}
+
+// drawVersion draws both copies of the version, protected by a BCH code, for
+// versions 7 and up.
+func (c *Code) drawVersion() {
+	if c.Version < 7 {
+		return
+	}
+	bits := versionBits(c.Version)
+	for i := 0; i < 18; i++ {
+		a, b := c.Size-11+i%3, i/3
+		c.setFunction(a, b, bit(bits, i))
+		c.setFunction(b, a, bit(bits, i))
+	}
+}
+
+// formatBits returns the 15 bits of format information: the level and mask
+// followed by their BCH error correction bits, XORed with a fixed pattern.
+func formatBits(level Level, mask int) int {
+	data := formatLevelBits[level]<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+// versionBits returns the 18 bits of version information: the version
+// followed by its BCH error correction bits.
+func versionBits(version int) int {
+	rem := version
+	for i := 0; i < 12; i++ {
+		rem = rem<<1 ^ (rem>>11)*0x1F25
+	}
+	return version<<12 | rem
+}
+
+// drawCodewords fills the modules which are not part of a function pattern
+// with the bits of the codewords, in the zig-zag order of two module wide
+// columns going up and down from the bottom right corner.
+func (c *Code) drawCodewords(codewords []byte) {
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5 // skip the vertical timing pattern
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < c.Size; vert++ {
+			y := vert
+			if upward {
+				y = c.Size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if !c.function[y*c.Size+x] && i < len(codewords)*8 {
+					c.dark[y*c.Size+x] = bit(int(codewords[i>>3]), 7-i&7)
+					i++
+				}
+				// Any remainder bits are left light.
+			}
+		}
+	}
+}
+
+// applyMask inverts the modules outside of the function patterns which are
+// selected by the mask pattern. Applying the same mask twice undoes it.
+func (c *Code) applyMask(mask int) {
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert && !c.function[y*c.Size+x] {
+				c.dark[y*c.Size+x] = !c.dark[y*c.Size+x]
+			}
+		}
+	}
+}
+
+// The weights of the penalty rules used to choose a mask.
+const (
+	penaltyRun     = 3  // plus one per module over 5 in a row of one color
+	penaltyBlock   = 3  // per 2x2 block of one color
+	penaltyFinder  = 40 // per pattern resembling a finder pattern
+	penaltyBalance = 10 // per 5% of imbalance between dark and light modules
+)
+
+// penalty scores how hard the code is to read. Lower is better.
+func (c *Code) penalty() int {
+	result := 0
+	for i := 0; i < c.Size; i++ {
+		result += c.linePenalty(func(j int) bool { return c.Black(j, i) })
+		result += c.linePenalty(func(j int) bool { return c.Black(i, j) })
+	}
+
+	dark := 0
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.Black(x, y) {
+				dark++
+			}
+			if x+1 < c.Size && y+1 < c.Size {
+				color := c.Black(x, y)
+				if color == c.Black(x+1, y) && color == c.Black(x, y+1) && color == c.Black(x+1, y+1) {
+					result += penaltyBlock
+				}
+			}
+		}
+	}
+
+	total := c.Size * c.Size
+	result += abs(dark*100/total-50) / 5 * penaltyBalance
+	return result
+}
+
+// finderLike is the 1:1:3:1:1 ratio of a finder pattern followed by four
+// light modules. It is also checked for in reverse.
+var finderLike = []bool{true, false, true, true, true, false, true, false, false, false, false}
+
+// linePenalty scores the runs of modules of one color and the patterns which
+// resemble a finder pattern in a row or column of modules.
+func (c *Code) linePenalty(black func(int) bool) int {
+	result := 0
+	run := 0
+	for i := 0; i < c.Size; i++ {
+		if i > 0 && black(i) == black(i-1) {
+			run++
+		} else {
+			run = 1
+		}
+		if run == 5 {
+			result += penaltyRun
+		} else if run > 5 {
+			result++
+		}
+	}
+
+	for i := 0; i+len(finderLike) <= c.Size; i++ {
+		forward, backward := true, true
+		for j, want := range finderLike {
+			forward = forward && black(i+j) == want
+			backward = backward && black(i+len(finderLike)-1-j) == want
+		}
+		if forward {
+			result += penaltyFinder
+		}
+		if backward {
+			result += penaltyFinder
+		}
+	}
+	return result
+}
+
+// bitBuffer is a sequence of bits which is appended to.
+type bitBuffer []bool
+
+func (b *bitBuffer) len() int { return len(*b) }
+
+// append appends the lowest n bits of value, most significant bit first.
+func (b *bitBuffer) append(value, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*b = append(*b, bit(value, i))
+	}
+}
+
+// bytes packs the bits, whose number must be a multiple of 8.
+func (b bitBuffer) bytes() []byte {
+	result := make([]byte, len(b)/8)
+	for i, set := range b {
+		if set {
+			result[i>>3] |= 1 << (7 - i&7)
+		}
+	}
+	return result
+}
+
+func bit(x, i int) bool {
+	return x>>i&1 != 0
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
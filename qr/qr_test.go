@@ -0,0 +1,271 @@
+package qr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+// TestReedSolomon checks the error correction codewords of the "HELLO WORLD"
+// example of https://www.thonky.com/qr-code-tutorial/.
+func TestReedSolomon(t *testing.T) {
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if got := rsRemainder(data, rsDivisor(10)); !bytes.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestFormatAndVersionBits(t *testing.T) {
+	for _, tt := range []struct {
+		level Level
+		mask  int
+		want  int
+	}{
+		{L, 0, 0b111011111000100},
+		{L, 4, 0b110011000101111},
+		{M, 0, 0b101010000010010},
+		{Q, 0, 0b011010101011111},
+		{H, 0, 0b001011010001001},
+	} {
+		if got := formatBits(tt.level, tt.mask); got != tt.want {
+			t.Errorf("formatBits(%v, %d) = %015b, want %015b", tt.level, tt.mask, got, tt.want)
+		}
+	}
+	if got, want := versionBits(7), 0b000111110010010100; got != want {
+		t.Errorf("versionBits(7) = %018b, want %018b", got, want)
+	}
+}
+
+// TestCapacity checks the tables against the byte mode capacities of the
+// specification.
+func TestCapacity(t *testing.T) {
+	capacities := map[int][4]int{
+		1:  {17, 14, 11, 7},
+		2:  {32, 26, 20, 14},
+		7:  {154, 122, 86, 64},
+		5:  {106, 84, 60, 44},
+		10: {271, 213, 151, 119},
+		15: {520, 412, 292, 220},
+		21: {929, 711, 509, 403},
+		25: {1273, 997, 715, 535},
+		30: {1732, 1370, 982, 742},
+		40: {2953, 2331, 1663, 1273},
+	}
+	for version, capacity := range capacities {
+		for level := L; level <= H; level++ {
+			n := capacity[level]
+			c, err := Encode(make([]byte, n), level)
+			if err != nil || c.Version != version {
+				t.Errorf("%d bytes at %v: got version %v (%v), want %d", n, level, c, err, version)
+			}
+			if version < 40 {
+				if c, _ := Encode(make([]byte, n+1), level); c == nil || c.Version != version+1 {
+					t.Errorf("%d bytes at %v: got %v, want version %d", n+1, level, c, version+1)
+				}
+			}
+		}
+	}
+	if _, err := Encode(make([]byte, 2954), L); !errors.Is(err, ErrTooLong) {
+		t.Errorf("got %v, want ErrTooLong", err)
+	}
+}
+
+func TestAlignmentPatternPositions(t *testing.T) {
+	for version, want := range map[int]string{
+		1:  "[]",
+		2:  "[6 18]",
+		7:  "[6 22 38]",
+		32: "[6 34 60 86 112 138]",
+		36: "[6 24 50 76 102 128 154]",
+		40: "[6 30 58 86 114 142 170]",
+	} {
+		if got := fmt.Sprint(alignmentPatternPositions(version)); got != want {
+			t.Errorf("version %d: got %s, want %s", version, got, want)
+		}
+	}
+}
+
+// TestRoundTrip decodes codes of every version and level.
+func TestRoundTrip(t *testing.T) {
+	for level := L; level <= H; level++ {
+		for version := minVersion; version <= maxVersion; version++ {
+			n := numDataCodewords(version, level) - 3
+			data := make([]byte, n)
+			for i := range data {
+				data[i] = byte(i*7 + version)
+			}
+			c, err := Encode(data, level)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Version != version {
+				t.Fatalf("%d bytes at %v: got version %d, want %d", n, level, c.Version, version)
+			}
+			got, err := decode(c)
+			if err != nil {
+				t.Fatalf("version %d level %v: %v", version, level, err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatalf("version %d level %v: decoded data differs", version, level)
+			}
+		}
+	}
+}
+
+// decode reads back the data of a code using only its modules.
+func decode(c *Code) ([]byte, error) {
+	size := c.Size
+	version := (size - 17) / 4
+
+	// Read the first copy of the format information.
+	read := 0
+	for i := 0; i <= 5; i++ {
+		read |= b2i(c.Black(8, i)) << i
+	}
+	read |= b2i(c.Black(8, 7))<<6 | b2i(c.Black(8, 8))<<7 | b2i(c.Black(7, 8))<<8
+	for i := 9; i < 15; i++ {
+		read |= b2i(c.Black(14-i, 8)) << i
+	}
+	level, mask := Level(-1), -1
+	for l := L; l <= H; l++ {
+		for m := 0; m < 8; m++ {
+			if formatBits(l, m) == read {
+				level, mask = l, m
+			}
+		}
+	}
+	if level < 0 {
+		return nil, fmt.Errorf("invalid format information %015b", read)
+	}
+
+	// Find the data modules of an empty code of the same version.
+	empty := &Code{Version: version, Level: level, Size: size, dark: make([]bool, size*size), function: make([]bool, size*size)}
+	empty.drawFunctionPatterns()
+	unmasked := &Code{Size: size, dark: append([]bool(nil), c.dark...), function: empty.function}
+	unmasked.applyMask(mask)
+
+	var bits bitBuffer
+	for right := size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right--
+		}
+		for vert := 0; vert < size; vert++ {
+			y := vert
+			if (right+1)&2 == 0 {
+				y = size - 1 - vert
+			}
+			for x := right; x > right-2; x-- {
+				if !empty.function[y*size+x] {
+					bits = append(bits, unmasked.Black(x, y))
+				}
+			}
+		}
+	}
+	codewords := bitBuffer(bits[:len(bits)/8*8]).bytes()
+
+	// De-interleave the blocks and check their error correction codewords.
+	numBlocks := numErrorCorrectionBlocks[level][version]
+	eccLen := eccCodewordsPerBlock[level][version]
+	shortLen := len(codewords)/numBlocks - eccLen
+	numShort := numBlocks - len(codewords)%numBlocks
+	blocks := make([][]byte, numBlocks)
+	i := 0
+	for k := 0; k <= shortLen; k++ {
+		for j := range blocks {
+			if k < shortLen || j >= numShort {
+				blocks[j] = append(blocks[j], codewords[i])
+				i++
+			}
+		}
+	}
+	var data []byte
+	for j := range blocks {
+		data = append(data, blocks[j]...)
+	}
+	for k := 0; k < eccLen; k++ {
+		for j := range blocks {
+			blocks[j] = append(blocks[j], codewords[i])
+			i++
+		}
+	}
+	for j, block := range blocks {
+		root := byte(1)
+		for k := 0; k < eccLen; k++ {
+			// Evaluate the block as a polynomial at each root of the generator.
+			var sum byte
+			for _, b := range block {
+				sum = gfMultiply(sum, root) ^ b
+			}
+			if sum != 0 {
+				return nil, fmt.Errorf("block %d: syndrome %d is %d", j, k, sum)
+			}
+			root = gfMultiply(root, 2)
+		}
+	}
+
+	// Parse the byte mode segment.
+	var in bitBuffer
+	for _, b := range data {
+		in.append(int(b), 8)
+	}
+	next := func(n int) int {
+		v := 0
+		for ; n > 0; n-- {
+			v = v<<1 | b2i(in[0])
+			in = in[1:]
+		}
+		return v
+	}
+	if mode := next(4); mode != 4 {
+		return nil, fmt.Errorf("unexpected mode %b", mode)
+	}
+	out := make([]byte, next(charCountBits(version)))
+	for i := range out {
+		out[i] = byte(next(8))
+	}
+	return out, nil
+}
+
+func b2i(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func TestRender(t *testing.T) {
+	c, err := Encode([]byte("https://golang.org/"), M)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := c.WritePNG(&buf, 3); err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	side := (c.Size + 2*QuietZone) * 3
+	if b := img.Bounds(); b.Dx() != side || b.Dy() != side {
+		t.Errorf("got %v image, want %d pixels per side", b, side)
+	}
+
+	var svg strings.Builder
+	if err := c.WriteSVG(&svg, 4); err != nil {
+		t.Fatal(err)
+	}
+	dark := 0
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			dark += b2i(c.Black(x, y))
+		}
+	}
+	if n := strings.Count(svg.String(), "h1v1h-1z"); n != dark {
+		t.Errorf("SVG has %d modules, want %d", n, dark)
+	}
+}
@@ -3,18 +3,29 @@ package main
 // Similar parenthesis based grouping is also allowed for "var" and "const" statements
 import (
 	"bufio"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"io/ioutil"
 	"log"
 	"math"
-	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
-	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/fakhir/learngo/pipeline"
+	"github.com/fakhir/learngo/regex"
+	"github.com/fakhir/learngo/semaphore"
+	"github.com/fakhir/learngo/subprocess"
+	"github.com/fakhir/learngo/workerpool"
 )
 
 // - Semicolons are optional at end of statements
@@ -23,19 +34,21 @@ import (
 //   all variable definitions are evaluated and finally the "func init()" function
 //   within the source file is evaluated. Each source file can have one (or more)
 //   init() function.
+// - The sections below print through their env parameter, whose Print, Printf and
+//   Println methods behave exactly like the fmt functions of the same name.
 
 func swap(a, b string) (string, string) {
 	return b, a
 }
 
-func variableDeclarations() {
+func variableDeclarations(env *Env) {
 	// Ways of defining integers
 	i := 0 // can only be used within functions, not at file scope, cannot be used for consts
 	var j = 1
 	var k int
 	var l int = 1
 
-	fmt.Println("Variables:", i, j, k, l)
+	env.Println("Variables:", i, j, k, l)
 
 	// Basic variable types are as follows:
 	// bool, string, float32, float64, complex64, complex128
@@ -51,7 +64,7 @@ func variableDeclarations() {
 		tcpPort      int16  = 8080
 	)
 
-	fmt.Printf("Variables:Group: %T %v, %T %v, %T %v\n", isInit, isInit, errorMessage, errorMessage, tcpPort, tcpPort)
+	env.Printf("Variables:Group: %T %v, %T %v, %T %v\n", isInit, isInit, errorMessage, errorMessage, tcpPort, tcpPort)
 
 	// Type conversions are explicit
 	num := 10
@@ -59,27 +72,27 @@ func variableDeclarations() {
 	numu := uint(numf)
 	const pi = 3.14
 
-	fmt.Printf("Variables: %T %v, %T %v, %T %v, %T %v\n", num, num, numf, numf, numu, numu, pi, pi)
+	env.Printf("Variables: %T %v, %T %v, %T %v, %T %v\n", num, num, numf, numf, numu, numu, pi, pi)
 
 	str := "this is a test string"
 	str = `this is a raw string without escape interpretation "\n"`
 	// String to bytes
 	byteStr := []byte(str)
 	runeStr := []rune(str)
-	fmt.Println("Variables:str", str)
-	fmt.Println("Variables:byte_str", byteStr)
-	fmt.Println("Variables:rune_str", runeStr)
+	env.Println("Variables:str", str)
+	env.Println("Variables:byte_str", byteStr)
+	env.Println("Variables:rune_str", runeStr)
 
 	// Bytes to string after reversing the string
 	for i, j := 0, len(byteStr)-1; i < j; i, j = i+1, j-1 {
 		byteStr[i], byteStr[j] = byteStr[j], byteStr[i]
 	}
 	str = string(byteStr)
-	fmt.Println("Variables:reversed", str)
+	env.Println("Variables:reversed", str)
 	return
 }
 
-func functionsInGo() {
+func functionsInGo(env *Env) {
 	// Functions cannot be nested but they can be assigned to variables.
 	// Methods cannot be assigned to variables and so cannot be defined within a function.
 	// Functions can be used before they are defined, within the same file.
@@ -96,7 +109,7 @@ func functionsInGo() {
 		return y, x
 	}
 	var first, second = swap("hello", "world")
-	fmt.Println("Functions:Swap:", first, second)
+	env.Println("Functions:Swap:", first, second)
 
 	// Function with multiple return values (named)
 	var square = func(a int, b int) (x int, y int) {
@@ -105,12 +118,12 @@ func functionsInGo() {
 		return
 	}
 	var numA, numB = square(4, 5)
-	fmt.Println("Functions:Square:", numA, numB)
+	env.Println("Functions:Square:", numA, numB)
 
 	// Callback functions
 	var callCallback = func(f func(string, string) (string, string)) {
 		a, b := f("one", "two")
-		fmt.Println("Functions:callback", a, b)
+		env.Println("Functions:callback", a, b)
 	}
 	callCallback(swap)
 
@@ -127,33 +140,33 @@ func functionsInGo() {
 		}
 		return total
 	}
-	fmt.Println("Functions:sumofnums", sumOfNums(10, 20, 30, 40, 50))
+	env.Println("Functions:sumofnums", sumOfNums(10, 20, 30, 40, 50))
 	sampleInputs := []int{100, 200, 300, 400, 500}
-	fmt.Println("Functions:sumofnums", sumOfNums(sampleInputs...))
+	env.Println("Functions:sumofnums", sumOfNums(sampleInputs...))
 	sampleInputsArray := [...]int{100, 200, 300, 400, 500}
 	sampleInputs2 := sampleInputsArray[:]
-	fmt.Println("Functions:sumofnums", sumOfNums(sampleInputs2[1:]...))
+	env.Println("Functions:sumofnums", sumOfNums(sampleInputs2[1:]...))
 }
 
-func controlFlow() {
-	fmt.Printf("Ctrlflow:")
+func controlFlow(env *Env) {
+	env.Printf("Ctrlflow:")
 	// Variable has local scope, braces are mandatory
 	for i := 0; i < 10; i++ {
-		fmt.Printf("%d", i)
+		env.Printf("%d", i)
 	}
 
 	// The for loop is also the while loop as semicolons are optional
 	// Omiting the loop condition creates an infinite loop: for { ... }
 	j := 10
 	for j < 20 {
-		fmt.Printf("%d", j)
+		env.Printf("%d", j)
 		j++
 	}
-	fmt.Println()
+	env.Println()
 
 	// The break and continue keywords also take an optional label to specify
 	// which loop to break.
-	fmt.Print("CtrlFlow:labels ")
+	env.Print("CtrlFlow:labels ")
 J:
 	for i := 0; i < 10; i++ {
 		for j := 0; j < 10; j++ {
@@ -161,40 +174,41 @@ J:
 				break J
 			}
 		}
-		fmt.Print(i)
+		env.Print(i)
 	}
-	fmt.Println()
+	env.Println()
 
 	// "if" also supports a variable initialition similar to "for"
 	if v := math.Pow(3, 2); v < 10 {
-		fmt.Println("Ctrlflow:If less than 10:", v)
+		env.Println("Ctrlflow:If less than 10:", v)
 	} else {
 		// "v" is also within scope of the "else" block
-		fmt.Println("Ctrlflow:If greater than 10:", v)
+		env.Println("Ctrlflow:If greater than 10:", v)
 	}
 
 	// Switch also has an optional initialization, no break needed, cases must be const
 	// Case values can be function calls. And default can come first.
-	switch os := runtime.GOOS; os {
+	// The env.goos is runtime.GOOS unless the refresher runs with -deterministic.
+	switch os := env.goos; os {
 	case "some other OS", "yes another OS": // Multiple cases can be separated by a comma
 		fallthrough // Use fallthrough explicitly to fall through to next case
 	default:
-		fmt.Println("Ctrlflow:", os)
+		env.Println("Ctrlflow:", os)
 	case "darwin":
-		fmt.Println("Ctrlflow:OS X")
+		env.Println("Ctrlflow:OS X")
 	case "linux":
-		fmt.Println("Ctrlflow:OS Linux")
+		env.Println("Ctrlflow:OS Linux")
 	}
 
 	// The switch condition can be skipped to write long if/else chains
-	t := time.Now()
+	t := env.clock.Now()
 	switch {
 	case t.Hour() >= 0 && t.Hour() < 12:
-		fmt.Println("Ctrlflow:Good morning")
+		env.Println("Ctrlflow:Good morning")
 	case t.Hour() >= 12 && t.Hour() < 17:
-		fmt.Println("Ctrlflow:Good afternoon")
+		env.Println("Ctrlflow:Good afternoon")
 	default:
-		fmt.Println("Ctrlflow:Good evening")
+		env.Println("Ctrlflow:Good evening")
 	}
 
 	// Another example if switch similar to if
@@ -204,30 +218,30 @@ J:
 	case '?', ' ', '&', '=', '+', '%':
 		shouldEscape = true
 	}
-	fmt.Println("Ctrlflow:shouldEscape", shouldEscape)
+	env.Println("Ctrlflow:shouldEscape", shouldEscape)
 
 	// A Type Switch statement can be used to check the type
 	var g interface{}
 	g = "sample text"
 	switch g.(type) {
 	case int:
-		fmt.Println("Ctrlflow:is int")
+		env.Println("Ctrlflow:is int")
 	case *int:
-		fmt.Println("Ctrlflow:is *int")
+		env.Println("Ctrlflow:is *int")
 	case string:
-		fmt.Println("Ctrlflow:is string")
+		env.Println("Ctrlflow:is string")
 	}
 }
 
-func deferredFunctions() {
+func deferredFunctions(env *Env) {
 	// A defer keyword before a function defers the function call until the surrounding
 	// function returns. And if there are multiple functions then they are called
 	// in a reverse (stack based) order.
-	fmt.Println("Defer:Start")
+	env.Println("Defer:Start")
 	for i := 0; i < 5; i++ {
-		defer fmt.Println("Defer:Count", i)
+		defer env.Println("Defer:Count", i)
 	}
-	fmt.Println("Defer:End")
+	env.Println("Defer:End")
 
 	// A deferred function can also modify named return values
 	var doSomething = func() (ret int) {
@@ -236,10 +250,10 @@ func deferredFunctions() {
 		return 1
 	}
 	x := doSomething()
-	fmt.Println("Defer:return value modified", x)
+	env.Println("Defer:return value modified", x)
 }
 
-func panicAndRecover() {
+func panicAndRecover(env *Env) {
 	// panic(): is a built-in function that stops the ordinary flow of control and begins panicking.
 	// When the function F calls panic, execution of F stops, any deferred functions in F are
 	// executed normally, and then F returns to its caller. To the caller, F then behaves like a
@@ -268,10 +282,10 @@ func panicAndRecover() {
 		nums[4] = 10
 	}
 
-	fmt.Println("Panic:willPanic", willPanic(panicyFunc))
+	env.Println("Panic:willPanic", willPanic(panicyFunc))
 }
 
-func pointersInGo() {
+func pointersInGo(env *Env) {
 	i, j := 10, 2189
 	ptrToI := &i
 	*ptrToI += 10
@@ -279,12 +293,12 @@ func pointersInGo() {
 	var ptrToJ *int = &j
 	*ptrToJ = *ptrToJ / 17
 
-	fmt.Println("Pointers:", i, j, ptrToI, ptrToJ, *ptrToI, *ptrToJ)
+	env.Println("Pointers:", i, j, ptrToI, ptrToJ, *ptrToI, *ptrToJ)
 
 	// Pointer aritematic is not allowed in Go
 }
 
-func structDataType() {
+func structDataType(env *Env) {
 	type Vertex struct {
 		// Field names starting with uppercase are accessible from other packages
 		// and those starting with lowercase are only accessible in the current package.
@@ -296,12 +310,12 @@ func structDataType() {
 	v3 := &Vertex{3, 4}
 
 	// Pointers also use dot notation for struct members (v3.X)
-	fmt.Printf("Struct:Vertex: v3.X=%d, %v, %v\n", v3.X, v1, v2)
+	env.Printf("Struct:Vertex: v3.X=%d, %v, %v\n", v3.X, v1, v2)
 
 	// Annotate struct with field names using %+v
 	// Print a value in Go syntax using %#v
 	// Print a quoted string using %q
-	fmt.Printf("Struct:Vertex:v1 %+v %#v %q\n", v1, v1, "what")
+	env.Printf("Struct:Vertex:v1 %+v %#v %q\n", v1, v1, "what")
 
 	type Student struct {
 		// These are anonymous field names. Their name is the same as their type.
@@ -311,24 +325,24 @@ func structDataType() {
 	}
 
 	student1 := Student{int: 10, string: "Fred"}
-	fmt.Println("Struct:Student", student1)
+	env.Println("Struct:Student", student1)
 }
 
-func arrayDataType() {
+func arrayDataType(env *Env) {
 	var myStrings [2]string
 	myStrings[0] = "hello"
 	myStrings[1] = "world"
-	fmt.Println("Arrays:", myStrings)
+	env.Println("Arrays:", myStrings)
 
 	// You can also skip the array size by using ... instead
 	primes := [...]int{2, 3, 5, 7, 11, 13}
-	fmt.Println("Arrays:", primes)
+	env.Println("Arrays:", primes)
 
 	// Slices are a window into an array which can grow (have separate len and cap)
 	var slicedPrimes []int = primes[1:4]
-	fmt.Println("Arrays:Sliced:", slicedPrimes)
+	env.Println("Arrays:Sliced:", slicedPrimes)
 	slicedPrimes[0] = 100
-	fmt.Println("Arrays:", primes)
+	env.Println("Arrays:", primes)
 
 	// Note: arrays are always passed by value to functions and slices
 	// are always passed by reference. Assigning one array to another copies
@@ -341,11 +355,11 @@ func arrayDataType() {
 		"Ford",
 		"Suzuki", // A comma is always needed at the end of each item, even the last one
 	}
-	fmt.Println("Arrays:cars:", cars)
+	env.Println("Arrays:cars:", cars)
 
 	// A slice literal first creates an array and then its slice
 	carsSlice := []string{"Toyota", "Vitz", "Nissan", "Prez"}
-	fmt.Println("Array:carslice:", carsSlice)
+	env.Println("Array:carslice:", carsSlice)
 
 	carFactory := []struct {
 		model int
@@ -356,36 +370,36 @@ func arrayDataType() {
 		{1995, "Suzuki"},
 	}
 
-	fmt.Println("Arrays:carfactory:", carFactory)
+	env.Println("Arrays:carfactory:", carFactory)
 }
 
-func moreOnSlices() {
+func moreOnSlices(env *Env) {
 	s1 := []int{0, 1, 2, 3, 4, 5, 6, 7}
-	fmt.Println("Slices:s1:", s1, "Cap:", cap(s1), "Len:", len(s1))
+	env.Println("Slices:s1:", s1, "Cap:", cap(s1), "Len:", len(s1))
 	s2 := s1[2:6]
-	fmt.Println("Slices:s2:", s2, "Cap:", cap(s2), "Len:", len(s2))
+	env.Println("Slices:s2:", s2, "Cap:", cap(s2), "Len:", len(s2))
 	// s2[4] = 20 -- this will cause a panic
 
 	// This will not extend the length of the slice
 	s2 = s2[0:]
-	fmt.Println("Slices:s2:", s2, "Cap:", cap(s2), "Len:", len(s2))
+	env.Println("Slices:s2:", s2, "Cap:", cap(s2), "Len:", len(s2))
 
 	// Extend the length of the slice explicitly to maximum capacity
 	// which is equivalent to s2 = s2[:6]
 	s2 = s2[:cap(s2)]
-	fmt.Println("Slices:s2:", s2, "Cap:", cap(s2), "Len:", len(s2))
+	env.Println("Slices:s2:", s2, "Cap:", cap(s2), "Len:", len(s2))
 
 	// The zero value of a slice is nil (zero len zero cap). This cannot
 	// be defined using the short syntax: s3 := []int
 	var s3 []int
 	if s3 == nil {
-		fmt.Println("Slices:s3 is nil")
+		env.Println("Slices:s3 is nil")
 	}
 
 	// Dynamically sized arrays can be creating by using the make(type, len, cap)
 	// function to create slices.
 	dynSlice1 := make([]int, 5, 10)
-	fmt.Println("Slices:dynSlice:", dynSlice1, "Cap:", cap(dynSlice1), "Len:", len(dynSlice1))
+	env.Println("Slices:dynSlice:", dynSlice1, "Cap:", cap(dynSlice1), "Len:", len(dynSlice1))
 
 	// Slices of slices (multidimensional)
 	board := [][]string{
@@ -400,27 +414,27 @@ func moreOnSlices() {
 	board[1][2] = "X"
 	board[2][2] = "O"
 	for i := 0; i < len(board); i++ {
-		fmt.Println(strings.Join(board[i], " "))
+		env.Println(strings.Join(board[i], " "))
 	}
 
 	// The append(slice, values...) function allows appending to a slice and
 	// grows the slice if required.
 	var growingSlice []int
 	growingSlice = append(growingSlice, 10, 11, 12)
-	fmt.Println("Slices:growingSlice", growingSlice, cap(growingSlice))
+	env.Println("Slices:growingSlice", growingSlice, cap(growingSlice))
 	growingSlice = append(growingSlice, 20, 30, 40)
-	fmt.Println("Slices:growingSlice", growingSlice, cap(growingSlice))
+	env.Println("Slices:growingSlice", growingSlice, cap(growingSlice))
 
 	// The copy function allows copying one slice to another.
 	var a = [...]int{0, 1, 2, 3, 4, 5, 6, 7}
 	var s4 = make([]int, 6)
 	copylen := copy(s4, a[0:])
-	fmt.Println("Slices:copy1:", copylen, s4) // prints 6 [0 1 2 3 4 5]
+	env.Println("Slices:copy1:", copylen, s4) // prints 6 [0 1 2 3 4 5]
 	copylen = copy(s4, s4[2:])
-	fmt.Println("Slices:copy2:", copylen, s4) // prints 4 [2 3 4 5 4 5]
+	env.Println("Slices:copy2:", copylen, s4) // prints 4 [2 3 4 5 4 5]
 }
 
-func mapDataType() {
+func mapDataType(env *Env) {
 	monthnames := map[string]int{
 		"Jan": 31, "Feb": 28, "Mar": 31, "Apr": 30,
 		"May": 31, "Jun": 30, "Jul": 31, "Aug": 31,
@@ -432,7 +446,7 @@ func mapDataType() {
 	for _, days := range monthnames {
 		daysinyear += days
 	}
-	fmt.Println("Map:daysinyear", daysinyear, "Days in Feb", monthnames["Feb"])
+	env.Println("Map:daysinyear", daysinyear, "Days in Feb", monthnames["Feb"])
 
 	// Inserting/deleting values
 	monthnames["January"] = 31
@@ -445,9 +459,9 @@ func mapDataType() {
 	// one. If this is done in an inner scope then the outer "ok" would be
 	// shadowed by a newly created inner scoped "ok".
 	value, ok := monthnames["Jan"]
-	fmt.Printf("Map:Jan exists: value:%v ok:%v\n", value, ok)
+	env.Printf("Map:Jan exists: value:%v ok:%v\n", value, ok)
 	value, ok = monthnames["January"]
-	fmt.Printf("Map:January exists: value:%v ok:%v\n", value, ok)
+	env.Printf("Map:January exists: value:%v ok:%v\n", value, ok)
 
 	// A map which returns functions
 	incFunc := map[int]func() int{
@@ -455,10 +469,10 @@ func mapDataType() {
 		2: func() int { return 20 },
 		3: func() int { return 30 },
 	}
-	fmt.Println("Map:incFunc", incFunc[1]())
+	env.Println("Map:incFunc", incFunc[1]())
 }
 
-func makeAndNew() {
+func makeAndNew(env *Env) {
 	// Remember that new(Type) returns a pointer to the zeroed value of Type.
 	// And make(Type, len, cap) is used to allocate slices, maps and channels ONLY,
 	// and returns an initialized (not zero'ed) Type itself instead of a pointer to it.
@@ -472,10 +486,10 @@ func makeAndNew() {
 	*n = make([]int, 10) // We're making it unneccessarily complex. Idomatic: x := make([]int, 10)
 	(*n)[0] = 10         // The brackets around (*n) are required.
 
-	fmt.Println("Allocation:", n, m)
+	env.Println("Allocation:", n, m)
 }
 
-func constructorsInGo() {
+func constructorsInGo(env *Env) {
 	type StudentInfo struct {
 		name string
 		age  int
@@ -498,33 +512,41 @@ func constructorsInGo() {
 	}
 
 	var student *StudentInfo = NewStudentInfo("Fred", 10)
-	fmt.Println("Constructors:", student)
+	env.Println("Constructors:", student)
 }
 
-func concurrencyAndChannels() {
+func concurrencyAndChannels(env *Env) {
 	// A channel has a specific type or can be the empty interface for generic type.
 	// Use make(chan int, 10) to create a channel which can buffer 10 items.
 	// The default is 0, which means the sender blocks until the receiver receives.
 	var numberChan chan int = make(chan int)
+	// The env.trace calls record the goroutines and channel operations for the
+	// -trace diagram. They do nothing unless -trace is given.
+	env.trace.Name(numberChan, "numberChan")
 
 	waitAndPrint := func(str string, seconds int) {
-		time.Sleep(time.Duration(seconds) * time.Second)
-		fmt.Println("Concurrent:", str, "is ready", seconds)
+		env.trace.Start(str)
+		env.clock.Sleep(time.Duration(seconds) * time.Second)
+		env.Println("Concurrent:", str, "is ready", seconds)
 		// Write to the channel
 		numberChan <- len(str)
+		env.trace.Send(numberChan, len(str))
 	}
 
 	go waitAndPrint("Tea", 2)
 	go waitAndPrint("Coffee", 1)
 
-	fmt.Println("Concurrent: Waiting for tea/coffee")
+	env.Println("Concurrent: Waiting for tea/coffee")
 	var bytesWritten int
 	bytesWritten = <-numberChan
-	bytesWritten += <-numberChan
-	fmt.Println("Concurrent: Bytes sent", bytesWritten)
+	env.trace.Recv(numberChan, bytesWritten)
+	coffee := <-numberChan
+	env.trace.Recv(numberChan, coffee)
+	bytesWritten += coffee
+	env.Println("Concurrent: Bytes sent", bytesWritten)
 }
 
-func moreOnChannels() {
+func moreOnChannels(env *Env) {
 	// - Channels have a specific type which can even be struct or the generic interface.
 	// - When passing a channel to a function as a parameter, use these types:
 	//   - func abc(<-chan int): For the read side of the channel.
@@ -557,75 +579,87 @@ func moreOnChannels() {
 	// - Concurrency (structuring a program as independently executing components) is
 	//   different from parallelism (executing calculations in parallel for efficiency
 	//   on multiple CPUs). Go can handle both but is primarily a concurrent language.
+	//
+	// Each pattern is shown by a sub-section below this one, which can be run on
+	// their own with -run moreOnChannels/.
 
-	// Below is an example of Fibonacci using channels
+	// Below is an example of Fibonacci using channels. The pipeline package provides
+	// stages connecting goroutines with channels: Tee duplicates a channel like dup3
+	// did, and every stage stops when the context is cancelled, so that no goroutine
+	// is left blocked once the section returns.
 
-	// dup3 duplicates the given channel into three separate channels.
-	dup3 := func(in <-chan int) (<-chan int, <-chan int, <-chan int) {
-		a, b, c := make(chan int, 2), make(chan int, 2), make(chan int, 2)
-		// goroutine to duplicate data on incoming channel to the three new channels.
-		go func() {
-			for {
-				x, ok := <-in
-				if !ok {
-					close(a)
-					close(b)
-					close(c)
-					break
-				}
-				a <- x
-				b <- x
-				c <- x
-			}
-		}()
-		return a, b, c
-	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	fib := func(count int) <-chan int {
 		x := make(chan int, 2)
-		a, b, out := dup3(x)
+		copies := pipeline.Tee(ctx, x, 3)
+		// The generator reads a and b in turns, so Buffer keeps the copies it
+		// has not read yet and Tee can go on with the next number.
+		a, b, out := pipeline.Buffer(ctx, copies[0], 2), pipeline.Buffer(ctx, copies[1], 2), copies[2]
+		env.trace.Name(x, "x")
+		env.trace.Name(a, "a")
+		env.trace.Name(b, "b")
+		env.trace.Name(out, "fib")
+		env.trace.Link(x, a, b, out) // the values sent on x are received from a, b and out
 		go func() {
+			env.trace.Start("fib")
+			defer func() {
+				close(x)
+				env.trace.Close(x)
+			}()
 			x <- 0
+			env.trace.Send(x, 0)
 			x <- 1
-			<-b
+			env.trace.Send(x, 1)
+			env.trace.Recv(b, <-b)
 			for ; count > 1; count = count - 1 {
-				x <- <-a + <-b
+				i := <-a
+				env.trace.Recv(a, i)
+				j := <-b
+				env.trace.Recv(b, j)
+				select {
+				case x <- i + j:
+					env.trace.Send(x, i+j)
+				case <-ctx.Done():
+					return
+				}
 			}
-			close(x)
 		}()
 		return out
 	}
 
-	fmt.Print("Channels:")
-	for num := range fib(7) {
-		fmt.Print(num, " ")
+	env.Print("Channels:")
+	nums := fib(7)
+	for num := range nums {
+		env.trace.Recv(nums, num)
+		env.Print(num, " ")
+	}
+	env.Println()
+
+	// Stages are chained by passing the output of one as the input of the next.
+	// Take stops reading after n values, the stages before it are released by
+	// cancel when the section returns.
+	even := pipeline.Filter(ctx, fib(30), func(n int) bool { return n%2 == 0 })
+	for batch := range pipeline.Batch(ctx, pipeline.Take(ctx, even, 5), 2) {
+		env.Println("Channels:even batch:", batch)
 	}
-	fmt.Println()
 
 	// Below is an example of a server/client model for handling concurrent requests.
+	// A fixed number of worker goroutines serve the requests sent on a bounded
+	// queue and send back the results. This is implemented by the workerpool
+	// package, which also recovers from panics of the requests and waits for its
+	// workers with a sync.WaitGroup when it is closed.
 
-	maxCPU := runtime.GOMAXPROCS(0)
+	maxCPU := env.maxProcs // runtime.GOMAXPROCS(0) unless running with -deterministic
 
 	type Request struct {
-		args        []int
-		f           func([]int) int
-		resultsChan chan int
+		args []int
+		f    func([]int) int
 	}
 
-	requestHandler := func(inQueue <-chan *Request) {
-		fmt.Println("***STARTED WORKER")
-		for req := range inQueue {
-			req.resultsChan <- req.f(req.args)
-		}
-		fmt.Println("***STOPPING WORKER")
-	}
-
-	serve := func(inQueue <-chan *Request, quit chan bool) {
-		for i := 0; i < maxCPU; i++ {
-			go requestHandler(inQueue)
-		}
-		fmt.Println("***WAITING QUIT")
-		<-quit
+	serve := func(ctx context.Context, req Request) (int, error) {
+		return req.f(req.args), nil
 	}
 
 	sum := func(a []int) (x int) {
@@ -635,22 +669,526 @@ func moreOnChannels() {
 		return
 	}
 
-	queue := make(chan *Request, maxCPU)
+	// The queue and results channels are hidden inside the pool, which records
+	// its own operations on them with the hooks of poolTrace.
+	pool := workerpool.New(workerpool.WithTrace(ctx, poolTrace(env.trace)), maxCPU, maxCPU, serve)
+	env.Println("Channels:started workers:", maxCPU)
+
+	go func() {
+		env.trace.Start("submitter")
+		for i := 0; i < maxCPU; i++ {
+			pool.Submit(ctx, Request{[]int{1, 2, 3, 4, 5, 6, 7, 8, 9}, sum})
+		}
+		// Closing the pool lets the workers stop once the queue is empty.
+		pool.Close()
+	}()
+
+	// The results channel is closed after the last request has been served.
+	results := pool.Results()
+	for res := range results {
+		env.trace.Recv(results, res.Value)
+		env.Println("Channels:concurrent sum:", res.Value)
+	}
+	env.Println("Channels:stopped workers")
+}
+
+// The sub-sections of moreOnChannels show the design patterns it lists.
+
+func infiniteWriter(env *Env) {
+	// The writer sends forever and the reader takes what it needs. The writer is
+	// then left blocked on its next send until the program exits: fine for a
+	// short program, but a leak in a server. See infiniteWriterWithQuit.
+	naturals := func() <-chan int {
+		out := make(chan int)
+		go func() {
+			for i := 0; ; i++ {
+				out <- i
+			}
+		}()
+		return out
+	}
+
+	nums := naturals()
+	env.Print("Patterns:infinite:")
+	for i := 0; i < 5; i++ {
+		env.Print(" ", <-nums)
+	}
+	env.Println()
+}
+
+func infiniteWriterWithQuit(env *Env) {
+	// The writer also selects on a quit channel. Closing it, rather than sending
+	// a value, stops any number of writers. The done channel lets the caller
+	// wait until the writer has stopped.
+	naturals := func(quit <-chan bool) (<-chan int, <-chan bool) {
+		out, done := make(chan int), make(chan bool)
+		go func() {
+			defer close(done)
+			for i := 0; ; i++ {
+				select {
+				case out <- i:
+				case <-quit:
+					env.Println("Patterns:quit: writer stopped after sending", i, "values")
+					return
+				}
+			}
+		}()
+		return out, done
+	}
+
 	quit := make(chan bool)
-	go serve(queue, quit)
+	nums, done := naturals(quit)
+	env.Print("Patterns:quit:")
+	for i := 0; i < 5; i++ {
+		env.Print(" ", <-nums)
+	}
+	env.Println()
+	close(quit)
+	<-done
+}
+
+func finiteWriter(env *Env) {
+	// The writer closes the channel after its last value. Receiving from a closed
+	// channel never blocks: it returns the zero value and ok == false.
+	squares := func(n int) <-chan int {
+		out := make(chan int)
+		go func() {
+			defer close(out)
+			for i := 1; i <= n; i++ {
+				out <- i * i
+			}
+		}()
+		return out
+	}
+
+	ch := squares(4)
+	env.Print("Patterns:finite:")
+	for sq := range ch { // ends when ch is closed
+		env.Print(" ", sq)
+	}
+	env.Println()
+	v, ok := <-ch
+	env.Println("Patterns:finite: after close:", v, ok)
+}
+
+func asyncFunction(env *Env) {
+	// An async function returns a channel right away and delivers its result on
+	// it later, like a future. The buffer of 1 lets the goroutine finish even if
+	// the caller never reads the result.
+	async := func(f func() int) <-chan int {
+		result := make(chan int, 1)
+		go func() {
+			result <- f()
+		}()
+		return result
+	}
+
+	slowSquare := func(n int) func() int {
+		return func() int {
+			env.clock.Sleep(10 * time.Millisecond)
+			return n * n
+		}
+	}
+	a, b := async(slowSquare(3)), async(slowSquare(4)) // both run concurrently
+	env.Println("Patterns:async: started both")
+	env.Println("Patterns:async: sum of squares:", <-a+<-b)
+}
+
+func channelSemaphore(env *Env) {
+	// A buffered channel is a counting semaphore: a send acquires one of its cap
+	// slots, waiting while all are taken, and a receive releases one.
+	const workers, limit = 5, 2
+	sem := make(chan struct{}, limit)
+	var running, peak atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}        // acquire
+			defer func() { <-sem }() // release
+
+			n := running.Add(1)
+			for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+			}
+			env.clock.Sleep(10 * time.Millisecond) // the critical section
+			running.Add(-1)
+		}()
+	}
+	wg.Wait()
+	env.Println("Patterns:semaphore:", workers, "workers, at most", limit, "at once:", peak.Load() <= limit)
+}
+
+func weightedSemaphore(env *Env) {
+	// A weighted semaphore lets each holder take several units at once, e.g. the
+	// megabytes of memory a job needs out of a budget. Acquire waits for the
+	// units, or until its context is done.
+	const budget = 10
+	sem := semaphore.NewWeighted(budget)
+	ctx := context.Background()
+
+	sem.Acquire(ctx, 6)
+	env.Println("Patterns:weighted: TryAcquire(5) with 6 of", budget, "held:", sem.TryAcquire(5))
+	sem.Release(6)
+
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	env.Println("Patterns:weighted: Acquire(11):", sem.Acquire(timeout, 11))
+
+	var held, peak atomic.Int64
+	var wg sync.WaitGroup
+	for _, size := range []int64{6, 5, 4, 3, 7} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := sem.Acquire(ctx, size); err != nil {
+				return
+			}
+			defer sem.Release(size)
+			h := held.Add(size)
+			for p := peak.Load(); h > p && !peak.CompareAndSwap(p, h); p = peak.Load() {
+			}
+			env.clock.Sleep(10 * time.Millisecond)
+			held.Add(-size)
+		}()
+	}
+	wg.Wait()
+	env.Println("Patterns:weighted: jobs of 25 units ran within the budget:", peak.Load() <= budget)
+}
+
+func selectTimeout(env *Env) {
+	// time.After, here env.clock.After, returns a channel which receives the time
+	// after a duration. Selecting on it along with the result bounds the wait. The
+	// result channel is buffered so that a late result does not block the
+	// goroutine forever.
+	fetch := func(d time.Duration) <-chan string {
+		result := make(chan string, 1)
+		go func() {
+			env.clock.Sleep(d)
+			result <- fmt.Sprint("fetched in ", d)
+		}()
+		return result
+	}
+
+	for _, d := range []time.Duration{10 * time.Millisecond, time.Second} {
+		select {
+		case r := <-fetch(d):
+			env.Println("Patterns:timeout:", r)
+		case <-env.clock.After(200 * time.Millisecond):
+			env.Println("Patterns:timeout: gave up after 200ms")
+		}
+	}
+	// The channel of After above is created anew for each iteration, bounding
+	// each wait. For a deadline on the whole loop, create it before the loop.
+	// A Ticker delivers the time repeatedly, until it is stopped.
+	ticker := env.clock.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	deadline := env.clock.After(350 * time.Millisecond)
+	ticks := 0
+	for waiting := true; waiting; {
+		select {
+		case <-ticker.C():
+			ticks++
+		case <-deadline:
+			waiting = false
+		}
+	}
+	env.Println("Patterns:timeout: ticks before the deadline:", ticks)
+}
+
+// countWith starts goroutines which each call increment n times, and waits
+// for all of them to finish.
+func countWith(goroutines, n int, increment func()) {
+	var wg sync.WaitGroup
+	wg.Add(goroutines)
+	for i := 0; i < goroutines; i++ {
+		go func() {
+			defer wg.Done()
+			for j := 0; j < n; j++ {
+				increment()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// The counters below are incremented n times by each of the goroutines and
+// return the final count, which should be goroutines*n.
+
+// racyCount shares a Counter without synchronization: *ctr++ reads, adds and
+// writes, and two goroutines may read the same value and both write value+1.
+func racyCount(goroutines, n int) int {
+	var ctr Counter
+	countWith(goroutines, n, func() { ctr.Increment() })
+	return int(ctr)
+}
+
+// mutexCount lets only one goroutine at a time increment the Counter.
+func mutexCount(goroutines, n int) int {
+	var mu sync.Mutex
+	var ctr Counter
+	countWith(goroutines, n, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		ctr.Increment()
+	})
+	return int(ctr)
+}
 
-	for i := 0; i < maxCPU; i++ {
-		req := &Request{[]int{1, 2, 3, 4, 5, 6, 7, 8, 9}, sum, make(chan int)}
-		queue <- req
-		fmt.Println("Channels:concurrent sum:", <-req.resultsChan)
+// atomicCount uses an add instruction which the CPU performs as one step.
+func atomicCount(goroutines, n int) int {
+	var ctr atomic.Int64
+	countWith(goroutines, n, func() { ctr.Add(1) })
+	return int(ctr.Load())
+}
+
+// channelCount gives the Counter to a single goroutine, which owns it: the
+// others send it increments instead of sharing the memory.
+func channelCount(goroutines, n int) int {
+	increments := make(chan int)
+	total := make(chan int)
+	go func() {
+		var ctr Counter
+		for range increments {
+			ctr.Increment()
+		}
+		total <- int(ctr)
+	}()
+	countWith(goroutines, n, func() { increments <- 1 })
+	close(increments)
+	return <-total
+}
+
+func dataRaces(env *Env) {
+	// - A data race happens when two goroutines access the same variable
+	//   concurrently and at least one of the accesses is a write. The result is
+	//   undefined: updates get lost, or worse, a value is only partly written.
+	// - "Do not communicate by sharing memory; instead, share memory by
+	//   communicating." Otherwise, protect the shared memory with the sync or
+	//   sync/atomic packages.
+	// - The race detector finds the races which happen while the program runs:
+	//   $ go run -race .
+	//   $ go test -race
+	// - Lost updates require the goroutines to run in parallel, so the racy
+	//   counter may be right with GOMAXPROCS=1. It is still wrong.
+
+	const goroutines, n = 4, 100000
+	counters := []struct {
+		name  string
+		count func(goroutines, n int) int
+	}{
+		{"racy", racyCount},
+		{"mutex", mutexCount},
+		{"atomic", atomicCount},
+		{"channel", channelCount},
 	}
+	for _, c := range counters {
+		if c.name == "racy" && raceEnabled {
+			// The race detector would report the race, and fail the tests.
+			env.Println("Races:racy: skipped with -race")
+			continue
+		}
+		start := env.clock.Now()
+		got := c.count(goroutines, n)
+		elapsed := env.clock.Now().Sub(start)
+		env.Printf("Races:%s: counted %d of %d, lost %d updates in %v\n", c.name, got, goroutines*n, goroutines*n-got, elapsed)
+	}
+}
+
+// The workloads below are CPU-bound: they compute without waiting for anything,
+// so more workers only make them faster if the workers run in parallel. Each
+// one splits its work among the given number of goroutines and returns a
+// checksum, which does not depend on the number of workers.
+
+// split calls work in parallel for each of the workers ranges which partition
+// [0, n), and returns the sum of their results.
+func split(n, workers int, work func(lo, hi int) int) int {
+	results := make(chan int, workers)
+	for w := 0; w < workers; w++ {
+		lo, hi := n*w/workers, n*(w+1)/workers
+		go func() {
+			results <- work(lo, hi)
+		}()
+	}
+	total := 0
+	for w := 0; w < workers; w++ {
+		total += <-results
+	}
+	return total
+}
 
-	quit <- true
-	close(queue)
-	time.Sleep(time.Duration(2) * time.Second)
+// parallelSum returns the sum of the squares of the numbers below n, wrapping
+// around on overflow. The work divides perfectly.
+func parallelSum(n, workers int) int {
+	return split(n, workers, func(lo, hi int) int {
+		sum := 0
+		for i := lo; i < hi; i++ {
+			sum += i * i
+		}
+		return sum
+	})
 }
 
-func typeSwitchAndTypeAssertion() {
+// primeSieve counts the primes below n with a sieve of Eratosthenes, whose
+// segments are sieved in parallel. The primes up to √n, which are needed by all
+// segments, are first found by a single goroutine: this serial part limits the
+// speedup.
+func primeSieve(n, workers int) int {
+	limit := int(math.Sqrt(float64(n)))
+	composite := make([]bool, limit+1)
+	var base []int
+	for i := 2; i <= limit; i++ {
+		if !composite[i] {
+			base = append(base, i)
+			for j := i * i; j <= limit; j += i {
+				composite[j] = true
+			}
+		}
+	}
+	return split(n, workers, func(lo, hi int) int {
+		lo = max(lo, 2)
+		if lo >= hi {
+			return 0
+		}
+		segment := make([]bool, hi-lo)
+		for _, p := range base {
+			for j := max(p*p, (lo+p-1)/p*p); j < hi; j += p {
+				segment[j-lo] = true
+			}
+		}
+		count := 0
+		for _, c := range segment {
+			if !c {
+				count++
+			}
+		}
+		return count
+	})
+}
+
+// matrixMultiply multiplies two n×n matrices, each worker computing some of
+// the rows of the product, and returns the sum of its elements. Filling the
+// matrices is serial.
+func matrixMultiply(n, workers int) int {
+	a, b := make([][]int, n), make([][]int, n)
+	for i := range a {
+		a[i], b[i] = make([]int, n), make([]int, n)
+		for j := range a[i] {
+			a[i][j], b[i][j] = (i+j)%10, (i*j)%10
+		}
+	}
+	return split(n, workers, func(lo, hi int) int {
+		sum := 0
+		row := make([]int, n)
+		for i := lo; i < hi; i++ {
+			clear(row)
+			for k, aik := range a[i] {
+				for j, bkj := range b[k] {
+					row[j] += aik * bkj
+				}
+			}
+			for _, v := range row {
+				sum += v
+			}
+		}
+		return sum
+	})
+}
+
+func concurrencyVsParallelism(env *Env) {
+	// - Concurrency is a way to structure a program as independently executing
+	//   goroutines. Parallelism is executing several of them at the same instant
+	//   on several CPUs. A concurrent program runs on one CPU too, interleaved.
+	// - runtime.GOMAXPROCS(n) sets how many goroutines can execute Go code at the
+	//   same time. It defaults to the number of CPUs, and GOMAXPROCS=1 makes a
+	//   program concurrent but not parallel.
+	// - Goroutines waiting for I/O or timers do not need a CPU, so even one CPU
+	//   serves many of them. CPU-bound goroutines only go faster in parallel.
+	// - Amdahl's law: if a fraction s of the work is serial, p CPUs speed it up
+	//   at most 1/(s + (1-s)/p) times, and never more than 1/s times.
+	// - The bench command measures the workloads above on this machine:
+	//   $ go run . bench -procs 1,2,4 -workers 1,2,4,8 -csv bench.csv
+
+	// The results do not depend on the number of workers.
+	const n = 100000
+	for _, workers := range []int{1, env.maxProcs} {
+		env.Println("Parallel:", workers, "workers:",
+			"sum", parallelSum(n, workers),
+			"primes", primeSieve(n, workers),
+			"matrix", matrixMultiply(n/1000, workers))
+	}
+
+	// The elapsed times are measured with env.clock: a deterministic run shows
+	// none since its virtual clock only advances when sleeping.
+	results, err := runBench(benchConfig{
+		workloads: benchWorkloads[:1],
+		procs:     []int{1, env.maxProcs},
+		workers:   []int{1, env.maxProcs},
+		runs:      1,
+		scale:     0.1,
+	}, env.clock.Now)
+	if err != nil {
+		env.Println("Parallel:", err)
+		return
+	}
+	writeBenchTable(env, results)
+}
+
+// The deadlock sections below block forever on purpose, so they only run when
+// -run selects them. Without the watchdog of the section runner, the runtime
+// would stop the whole program with "fatal error: all goroutines are asleep -
+// deadlock!", but only if every goroutine is blocked.
+
+func deadlockReadTooMany(env *Env) {
+	// Two goroutines send a value each but three values are received: the third
+	// receive waits for a sender which never comes.
+	numberChan := make(chan int)
+	for _, str := range []string{"Tea", "Coffee"} {
+		go func(str string) {
+			numberChan <- len(str)
+		}(str)
+	}
+	total := 0
+	for i := 0; i < 3; i++ {
+		total += <-numberChan
+		env.Println("Deadlock: received", i+1, "values")
+	}
+	env.Println("Deadlock: total", total) // never printed
+}
+
+func deadlockLockTwice(env *Env) {
+	// A sync.Mutex is not reentrant: locking it again from the goroutine which
+	// holds it waits for itself to unlock it.
+	var mu sync.Mutex
+	mu.Lock()
+	env.Println("Deadlock: locked once")
+	mu.Lock()
+	env.Println("Deadlock: locked twice") // never printed
+}
+
+func deadlockWaitForEachOther(env *Env) {
+	// Each goroutine sends to the other before receiving from it. Unbuffered
+	// sends wait for a receiver, so both wait forever. Buffering one of the
+	// channels or receiving in one of them first breaks the cycle.
+	ping, pong := make(chan string), make(chan string)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		ping <- "ping"
+		<-pong
+	}()
+	go func() {
+		defer wg.Done()
+		pong <- "pong"
+		<-ping
+	}()
+	env.Println("Deadlock: waiting for ping and pong")
+	wg.Wait()
+	env.Println("Deadlock: done") // never printed
+}
+
+func typeSwitchAndTypeAssertion(env *Env) {
 	// - Methods in Go can be defined for any custom type and not just structs.
 	// - An interface is an abstract set of methods expected to be implemented by
 	//   concrete types.
@@ -662,17 +1200,17 @@ func typeSwitchAndTypeAssertion() {
 	var value interface{} = "hello"
 	switch str := value.(type) {
 	case string:
-		fmt.Println("Types:string", str)
+		env.Println("Types:string", str)
 	case Stringer:
-		fmt.Println("Types:stringer", str.String())
+		env.Println("Types:stringer", str.String())
 	}
 
 	// Another possibility is to perform a direct conversion using a type assertion
 	str, ok := value.(string)
 	if ok {
-		fmt.Println("Types:Conversion success", str)
+		env.Println("Types:Conversion success", str)
 	} else {
-		fmt.Println("Types:Conversion failure")
+		env.Println("Types:Conversion failure")
 	}
 }
 
@@ -702,7 +1240,7 @@ func (ctr *Counter) Decrement() int {
 	return int(*ctr)
 }
 
-func methodsAndInterfaces() {
+func methodsAndInterfaces(env *Env) {
 	// The Counter type implicitly satisfies the Incrementer interface.
 	var ctrCounter Counter
 	ctrCounter.Increment()
@@ -713,9 +1251,9 @@ func methodsAndInterfaces() {
 	var ctrIncrementer Incrementer = ctrCounterPtr
 	ctrIncrementer.Increment()
 
-	fmt.Println("Interfaces:ctrCounter", ctrCounter)
-	fmt.Println("Interfaces:ctrCounterPtr", *ctrCounterPtr)
-	fmt.Println("Interfaces:ctrIncrementer", ctrIncrementer)
+	env.Println("Interfaces:ctrCounter", ctrCounter)
+	env.Println("Interfaces:ctrCounterPtr", *ctrCounterPtr)
+	env.Println("Interfaces:ctrIncrementer", ctrIncrementer)
 
 	// Most interface conversions in Golang are explicit and are thus checked at
 	// compile time. But sometimes the check is implicit. For example, the
@@ -767,13 +1305,15 @@ func methodsAndInterfaces() {
 		newJob := func(command string, logger *log.Logger) *Job {
 			return &Job{command, logger}
 		}
-		job := newJob("dir", log.New(os.Stdout, "Interfaces:Job:", log.Ldate))
+		// The date is that of env.clock rather than the log.Ldate flag, so that it
+		// is fixed with -deterministic.
+		job := newJob("dir", log.New(env, "Interfaces:Job:"+env.clock.Now().Format("2006/01/02 "), 0))
 		// The Println method is inherited from log.Logger.
 		job.Println("Job created")
 	}()
 }
 
-func errorHandling() {
+func errorHandling(env *Env) {
 	// See "go doc builtin.error" for definition of the error interface.
 	//
 	// This example implements a design pattern for handling errors within a package.
@@ -782,128 +1322,371 @@ func errorHandling() {
 	// the package user's code, which is the recommended practice. Library packages
 	// should return meaning errors instead of causing a panic.
 
-	// // Error is the type of a parse error; it satisfies the error interface.
-	// // Another way to define this is to embed an "err Error" within a struct
-	// // and then implement a forwarding Error method which calls err.Error().
-	// type Error string
-	// func (e Error) Error() string {
-	// 	return string(e)
-	// }
-
-	// // error is a method of *Regexp that reports parsing errors by
-	// // panicking with an Error.
-	// func (regexp *Regexp) error(err string) {
-	// 	panic(Error(err))
-	// }
-
-	// // Compile returns a parsed representation of the regular expression.
-	// func Compile(str string) (regexp *Regexp, err error) {
-	// 	regexp = new(Regexp)
-	// 	// doParse will panic if there is a parse error.
-	// 	defer func() {
-	// 		if e := recover(); e != nil {
-	// 			regexp = nil    // Clear return value.
-	// 			err = e.(Error) // Will re-panic if not a parse error.
-	// 		}
-	// 	}()
-	// 	return regexp.doParse(str), nil
-	// }
-}
-
-func communicationInGo() {
-	cat := func(filename string) int {
-		f, err := os.Open(filename)
+	// The regex package implements this pattern in a small regular expression
+	// engine. Error is the type of its syntax errors; it satisfies the error
+	// interface:
+	//
+	//	type Error struct {
+	//		Msg  string // the description of the error, e.g. "missing closing )"
+	//		Expr string // the offending part of the expression
+	//	}
+	//
+	// Its parser reports a syntax error by panicking with an Error:
+	//
+	//	func (p *parser) error(msg, expr string) {
+	//		panic(Error{msg, expr})
+	//	}
+	//
+	// Compile recovers the panic and returns the Error instead:
+	//
+	//	func Compile(expr string) (re *Regexp, err error) {
+	//		// The parser panics with an Error if there is a syntax error.
+	//		defer func() {
+	//			if e := recover(); e != nil {
+	//				re = nil        // Clear the return value.
+	//				err = e.(Error) // Will re-panic if not a syntax error.
+	//			}
+	//		}()
+	//		p := &parser{expr: expr}
+	//		return &Regexp{expr: expr, prog: compile(p.parse())}, nil
+	//	}
+	const text = "pi is about 3.14, or 22/7"
+	for _, expr := range []string{`[0-9]+\.[0-9]*`, `\d+/\d+|π`, `(\d+`, `[9-0]`, `+\d`} {
+		re, err := regex.Compile(expr)
 		if err != nil {
-			log.Fatal(err)
+			env.Printf("Errors:%s fails: %v (re is nil: %v)\n", expr, err, re == nil)
+			continue
 		}
-		defer f.Close()
+		env.Printf("Errors:%s finds %q\n", re, re.FindString(text))
+	}
 
-		buf := make([]byte, 256)
-		count := 0
-		for {
-			n, err := f.Read(buf)
-			if n != 0 {
-				//os.Stdout.Write(buf[:n])
-				fmt.Print("Comm:cat:", string(buf[:n]))
-				count += n
-			}
-			if err == io.EOF {
-				break
-			} else if err != nil {
-				log.Fatal(err)
-			}
-		}
-		return count
+	// A caller can still tell a syntax error apart from other errors, and get
+	// its details, with errors.As.
+	_, err := regex.Compile(`a**`)
+	var syntaxErr regex.Error
+	if errors.As(err, &syntaxErr) {
+		env.Printf("Errors:syntax error %q in %q\n", syntaxErr.Msg, syntaxErr.Expr)
 	}
+}
 
-	catBuffered := func(filename string) int {
-		f, err := os.Open(filename)
-		if err != nil {
-			log.Fatal(err)
+// The helpers of communicationInGo return their errors instead of calling
+// log.Fatal, which would end the whole refresher. Each error is wrapped with
+// fmt.Errorf and the %w verb, which adds what the helper was doing to the
+// message but keeps the original error for errors.Is and errors.As.
+
+// cat prints a file of fsys through env 256 bytes at a time and returns the
+// number of bytes it printed. An fs.FS is a read-only tree of files, such as
+// os.DirFS, an embedded directory or a zip archive, which makes the helper
+// usable, and testable, on any of them.
+func cat(env *Env, fsys fs.FS, filename string) (int, error) {
+	f, err := fsys.Open(filename)
+	if err != nil {
+		return 0, fmt.Errorf("cat: %w", err)
+	}
+	defer f.Close()
+
+	count, err := readChunks(f, make([]byte, 256), func(chunk []byte) {
+		//env.Write(chunk)
+		env.Print("Comm:cat:", string(chunk))
+	})
+	if err != nil {
+		return count, fmt.Errorf("cat: %w", err)
+	}
+	return count, nil
+}
+
+// readChunks reads r into buf until the end, calls fn with every chunk it read
+// and returns the number of bytes read. The memory it uses does not depend on
+// the size of the input, which is how the cat command streams its files.
+func readChunks(r io.Reader, buf []byte, fn func(chunk []byte)) (int, error) {
+	count := 0
+	for {
+		n, err := r.Read(buf)
+		if n != 0 {
+			fn(buf[:n])
+			count += n
 		}
-		defer f.Close()
-		rd := bufio.NewReader(f)
-		wr := bufio.NewWriter(os.Stdout)
-		defer wr.Flush()
+		if err == io.EOF {
+			return count, nil
+		} else if err != nil {
+			return count, err
+		}
+	}
+}
 
-		count := 0
-		for {
-			line, err := rd.ReadString('\n')
-			if line != "" {
-				fmt.Print("Comm:catbuf:", line)
+// catBuffered prints a file of fsys through env a line at a time and returns
+// the number of lines it printed.
+func catBuffered(env *Env, fsys fs.FS, filename string) (int, error) {
+	f, err := fsys.Open(filename)
+	if err != nil {
+		return 0, fmt.Errorf("catBuffered: %w", err)
+	}
+	defer f.Close()
+	rd := bufio.NewReader(f)
+
+	count, err := readLines(rd, func(line []byte) {
+		env.Print("Comm:catbuf:", string(line))
+	})
+	if err != nil {
+		return count, fmt.Errorf("catBuffered: %w", err)
+	}
+	return count, nil
+}
+
+// readLines calls fn with every line of rd, newline included, and returns the
+// number of lines. A line longer than the buffer of rd is passed in pieces, so
+// that the memory used does not depend on the input: the wc command counts its
+// files with it.
+func readLines(rd *bufio.Reader, fn func(line []byte)) (int, error) {
+	count := 0
+	for {
+		line, err := rd.ReadSlice('\n')
+		if len(line) != 0 {
+			fn(line)
+			if err != bufio.ErrBufferFull {
 				count++
 			}
-			if err == io.EOF {
-				break
-			} else if err != nil {
-				log.Fatal(err)
-			}
 		}
-		return count
+		if err == io.EOF {
+			return count, nil
+		} else if err != nil && err != bufio.ErrBufferFull {
+			return count, err
+		}
 	}
+}
 
-	if len(os.Args) == 2 {
-		cat(os.Args[1])
-	} else {
-		cat("/etc/hosts")
+// CommandFailedError is returned by runCommand when the command ran but exited
+// with a non-zero status. A custom error type carries details which callers
+// can get with errors.As, where a message would have to be parsed.
+type CommandFailedError struct {
+	Command  []string // the name and arguments of the command
+	ExitCode int
+	Stderr   string // what the command printed on its standard error
+	Err      error  // the underlying *subprocess.ExitError, which wraps an *exec.ExitError
+}
+
+func (e *CommandFailedError) Error() string {
+	msg := fmt.Sprintf("%s: exit status %d", strings.Join(e.Command, " "), e.ExitCode)
+	if stderr := strings.TrimSpace(e.Stderr); stderr != "" {
+		msg += ": " + stderr
 	}
-	catBuffered("/etc/resolv.conf")
+	return msg
+}
+
+// Unwrap returns the underlying error, so that errors.Is and errors.As also
+// look at it.
+func (e *CommandFailedError) Unwrap() error {
+	return e.Err
+}
+
+// runCommand runs a command and prints its standard output through env, as the
+// command prints it. The subprocess package does the work, see execCommands.
+func runCommand(env *Env, cmdname string, cmdargs ...string) error {
+	res, err := subprocess.Run(context.Background(), subprocess.Cmd{Name: cmdname, Args: cmdargs, Stdout: env})
+	var exitErr *subprocess.ExitError
+	if errors.As(err, &exitErr) {
+		return &CommandFailedError{append([]string{cmdname}, cmdargs...), res.ExitCode, string(res.Stderr), err}
+	} else if err != nil {
+		return fmt.Errorf("runCommand: %w", err) // e.g. exec.ErrNotFound
+	}
+	return nil
+}
+
+// errHTTPStatus is wrapped by the errors of httpGet for a response whose status
+// is not 200 OK. Callers check for such a sentinel error with errors.Is.
+var errHTTPStatus = errors.New("unexpected HTTP status")
 
-	runCommand := func(cmdname string, cmdargs ...string) {
-		cmd := exec.Command(cmdname, cmdargs...)
-		out, err := cmd.Output()
-		if out != nil && len(out) != 0 {
-			os.Stdout.Write(out)
+// httpGet prints and returns the body of the web page at url.
+func httpGet(env *Env, url string) (string, error) {
+	r, err := http.Get(url)
+	if err != nil {
+		return "", fmt.Errorf("httpGet: %w", err)
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("httpGet %s: %w: %s", url, errHTTPStatus, r.Status)
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return "", fmt.Errorf("httpGet %s: %w", url, err)
+	}
+	dataStr := string(data)
+	env.Println(dataStr)
+	return dataStr, nil
+}
+
+func communicationInGo(env *Env) {
+	// A failure of a helper is reported with env.Fail, which marks the section
+	// as failed but lets it go on with the next helper. The refresher then
+	// goes on with the next section, and exits with status 1 at the end.
+	// The files are read from the filesystem selected by -fs, e.g. with
+	// $ go run . -run communicationInGo -fs sample
+	// $ go run . -run communicationInGo -fs files.zip -file notes.txt
+	fsys, err := openFS(*fileSystem)
+	if err != nil {
+		env.Fail(err)
+	} else {
+		defer closeFS(fsys)
+		if name, err := fsName(*fileSystem, *catFile); err != nil {
+			env.Fail(err)
+		} else if _, err := cat(env, fsys, name); err != nil {
+			env.Fail(err)
 		}
-		if err != nil {
-			log.Fatal(err)
+		if _, err := catBuffered(env, fsys, "etc/resolv.conf"); err != nil {
+			env.Fail(err)
 		}
 	}
 
-	runCommand("ls", "-l")
+	if err := runCommand(env, "ls", "-l"); err != nil {
+		env.Fail(err)
+	}
 
 	// Networking can be used by importing the "net" package and using net.Dial:
 	// conn, err := Dial("tcp", "192.0.32.10:80")
 
-	httpGet := func(url string) (string, error) {
-		r, err := http.Get(url)
-		if err != nil {
-			log.Fatal(err)
-		}
-		data, err := ioutil.ReadAll(r.Body)
-		dataStr := string(data)
-		r.Body.Close()
-		if err == nil {
-			fmt.Println(dataStr)
+	if _, err := httpGet(env, "http://www.google.com/robots.txt"); err != nil {
+		env.Fail(err)
+	}
+}
+
+func wrappedErrors(env *Env) {
+	// errors.Is(err, target) reports whether err, or any error it wraps, is
+	// target. It is used with sentinel errors such as io.EOF and fs.ErrNotExist.
+	_, err := cat(env, sampleFS, "no/such/file")
+	env.Println("Wrapped:", err)
+	env.Println("Wrapped:is fs.ErrNotExist:", errors.Is(err, fs.ErrNotExist))
+
+	// errors.As(err, &target) finds the first error in the chain which can be
+	// assigned to target, a pointer to an error type, and sets target to it.
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		env.Println("Wrapped:as *fs.PathError:", pathErr.Op, pathErr.Path)
+	}
+
+	// Formatting with %v instead of %w keeps the message but breaks the chain.
+	flattened := fmt.Errorf("cat: %v", errors.Unwrap(err))
+	env.Println("Wrapped:flattened is fs.ErrNotExist:", errors.Is(flattened, fs.ErrNotExist))
+
+	// A custom error type carries the details of the failure.
+	err = runCommand(env, "sh", "-c", "echo oops >&2; exit 3")
+	var cmdErr *CommandFailedError
+	if errors.As(err, &cmdErr) {
+		env.Printf("Wrapped:command %q exited with %d, stderr %q\n", cmdErr.Command, cmdErr.ExitCode, cmdErr.Stderr)
+	}
+	// Its Unwrap method exposes the *exec.ExitError it wraps.
+	var exitErr *exec.ExitError
+	env.Println("Wrapped:as *exec.ExitError:", errors.As(err, &exitErr))
+
+	err = runCommand(env, "no-such-command")
+	env.Println("Wrapped:is exec.ErrNotFound:", errors.Is(err, exec.ErrNotFound))
+
+	// The errors of the net/http package wrap a *url.Error.
+	_, err = httpGet(env, "gopher://example.com/")
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		env.Println("Wrapped:as *url.Error:", urlErr.Op, urlErr.URL)
+	}
+	env.Println("Wrapped:is errHTTPStatus:", errors.Is(err, errHTTPStatus))
+
+	// errors.Join combines several errors into one, which prints them on
+	// separate lines. errors.Is and errors.As look at each of them.
+	var errs []error
+	for _, name := range []string{"no/such/file", "etc"} {
+		if _, err := catBuffered(env, sampleFS, name); err != nil {
+			errs = append(errs, err)
 		}
-		return dataStr, err
 	}
+	// cmdErr is nil if sh is missing. Joined as a nil *CommandFailedError, it
+	// would be a non-nil error whose Error method panics.
+	if cmdErr != nil {
+		errs = append(errs, cmdErr)
+	}
+	joined := errors.Join(errs...)
+	env.Println("Wrapped:joined:", joined)
+	env.Println("Wrapped:joined is fs.ErrNotExist:", errors.Is(joined, fs.ErrNotExist))
+	env.Println("Wrapped:joined as *CommandFailedError:", errors.As(joined, &cmdErr))
+	// Joining no errors, or only nil ones, returns nil.
+	env.Println("Wrapped:join of nil:", errors.Join(nil, nil) == nil)
+}
 
-	httpGet("http://www.google.com/robots.txt")
+func execCommands(env *Env) {
+	// The subprocess package runs a command with the options of exec.Cmd and
+	// waits for it. Its output is streamed line by line as the command prints
+	// it, each line prefixed. Stderr works the same, but its lines would be
+	// interleaved with those of stdout in no particular order.
+	ctx := context.Background()
+	res, err := subprocess.Run(ctx, subprocess.Cmd{
+		Name:         "sh",
+		Args:         []string{"-c", "echo one; echo two; echo warning >&2"},
+		Stdout:       env,
+		StdoutPrefix: "Exec:stdout| ",
+	})
+	if err != nil {
+		env.Fail(err)
+	}
+	env.Printf("Exec:exit code %d, stderr %q\n", res.ExitCode, res.Stderr)
+
+	// Without a writer, the output is captured in the result. The command
+	// reads its standard input from Stdin, and Env adds to the environment.
+	res, err = subprocess.Run(ctx, subprocess.Cmd{
+		Name:  "sh",
+		Args:  []string{"-c", `tr a-z A-Z; echo "$GREETING from $PWD"`},
+		Dir:   "/",
+		Env:   []string{"GREETING=hello"},
+		Stdin: strings.NewReader("fed through stdin\n"),
+	})
+	if err != nil {
+		env.Fail(err)
+	}
+	env.Printf("Exec:captured %q\n", res.Stdout)
+
+	// A non-zero exit status is an *subprocess.ExitError, which holds the
+	// result, captured standard error included.
+	_, err = subprocess.Run(ctx, subprocess.Cmd{Name: "sh", Args: []string{"-c", "echo bad input >&2; exit 3"}})
+	var exitErr *subprocess.ExitError
+	if errors.As(err, &exitErr) {
+		env.Println("Exec:", err)
+		env.Printf("Exec:exit code %d, stderr %q\n", exitErr.Result.ExitCode, exitErr.Result.Stderr)
+	}
+
+	// When the context is done, the command is killed along with the
+	// processes it started, here a sleep in the background.
+	timeout, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	res, err = subprocess.Run(timeout, subprocess.Cmd{Name: "sh", Args: []string{"-c", "sleep 10 & sleep 10"}})
+	env.Println("Exec:timed out:", errors.Is(err, context.DeadlineExceeded))
+	env.Printf("Exec:killed by signal %q, exit code %d\n", res.Signal, res.ExitCode)
+	env.Println("Exec:killed in time:", res.Duration < time.Second)
 }
 
 func main() {
-	fmt.Println("Hello world", rand.Intn(100))
+	flag.Usage = usage
+	flag.Parse()
+	if *listSections {
+		printSections(os.Stdout)
+		return
+	}
+	env, err := envFromFlags()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if flag.NArg() > 0 {
+		err := runSubcommand(env, flag.Args())
+		if status, ok := err.(exitStatus); ok {
+			os.Exit(int(status))
+		} else if err == errReported {
+			os.Exit(1)
+		} else if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	selected, err := matchSections(*runSections)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	env.Println("Hello world", env.rand.Intn(100))
 
 	// Getting help:
 	// $ go doc package-name/package-name symbol-name
@@ -914,24 +1697,35 @@ func main() {
 	//
 	// Source of built-in packages can be found here: /usr/share/go/src/
 
-	variableDeclarations()
-	functionsInGo()
-	controlFlow()
-	deferredFunctions()
-	panicAndRecover()
-	pointersInGo()
-	structDataType()
-	arrayDataType()
-	moreOnSlices()
-	mapDataType()
-	makeAndNew()
-	constructorsInGo()
-	concurrencyAndChannels()
-	moreOnChannels()
-	typeSwitchAndTypeAssertion()
-	methodsAndInterfaces()
-	errorHandling()
-	communicationInGo()
-
-	setupWebserv()
+	// Each topic below is a section registered in refresher_sections.go. Use
+	// -list to print their names and -run to select some of them:
+	// $ go run . -run 'Slices|Map'
+
+	// Unless they wait for the user, the sections run under a watchdog which
+	// explains where they are stuck and goes on with the next one. Try it with
+	// -run deadlock.
+	// A section which fails, for instance because a file is missing, does not
+	// stop the others: it prints its errors with env.Fail as they happen, and
+	// the failed sections are listed at the end.
+	var failed []string
+	for _, s := range selected {
+		if s.interactive || *watchdogTimeout == 0 {
+			if err := s.run(env); err != nil {
+				failed = append(failed, s.name)
+			}
+		} else if !runWatched(env, s, *watchdogTimeout) {
+			failed = append(failed, s.name)
+		}
+	}
+	// With -trace, the channel operations of the sections are drawn as a
+	// sequence diagram, e.g. go run . -run Channels -trace=svg -trace-out=t.svg
+	if env.trace != nil {
+		if err := writeTrace(env.trace); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if len(failed) > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d sections failed: %s\n", len(failed), len(selected), strings.Join(failed, ", "))
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// benchWorkload is a CPU-bound workload of concurrencyVsParallelism.
+type benchWorkload struct {
+	name string
+	size int // for a run of about 100ms on one CPU
+	run  func(size, workers int) int
+}
+
+var benchWorkloads = []benchWorkload{
+	{"parallelSum", 200_000_000, parallelSum},
+	{"primeSieve", 30_000_000, primeSieve},
+	{"matrixMultiply", 400, matrixMultiply},
+}
+
+// benchConfig selects the measurements made by runBench.
+type benchConfig struct {
+	workloads []benchWorkload
+	procs     []int   // GOMAXPROCS values
+	workers   []int   // numbers of goroutines
+	runs      int     // the best of runs is kept
+	scale     float64 // multiplies the size of the workloads
+}
+
+// benchResult is the measurement of a workload with GOMAXPROCS set to procs.
+type benchResult struct {
+	workload string
+	procs    int
+	workers  int
+	elapsed  time.Duration
+	// speedup is relative to the first measurement of the workload, normally
+	// with a single worker and GOMAXPROCS=1. It is NaN if a time is zero.
+	speedup float64
+	// serial is the experimentally determined serial fraction of the work
+	// (Karp-Flatt metric) given the parallelism min(procs, workers), which
+	// Amdahl's law assumes to be constant. It is NaN without parallelism.
+	serial float64
+}
+
+// runBench measures every workload for every combination of GOMAXPROCS and
+// number of workers, timing them with now. It restores GOMAXPROCS, and fails
+// if the result of a workload depends on the number of workers.
+func runBench(cfg benchConfig, now func() time.Time) ([]benchResult, error) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(0))
+	var results []benchResult
+	for _, w := range cfg.workloads {
+		size := max(1, int(float64(w.size)*cfg.scale))
+		first := len(results)
+		checksum := 0
+		for _, procs := range cfg.procs {
+			runtime.GOMAXPROCS(procs)
+			for _, workers := range cfg.workers {
+				r := benchResult{workload: w.name, procs: procs, workers: workers, elapsed: math.MaxInt64}
+				for i := 0; i < max(cfg.runs, 1); i++ {
+					start := now()
+					sum := w.run(size, workers)
+					r.elapsed = min(r.elapsed, now().Sub(start))
+					if len(results) == first && i == 0 {
+						checksum = sum
+					} else if sum != checksum {
+						return nil, fmt.Errorf("%s: got %d with %d workers instead of %d", w.name, sum, workers, checksum)
+					}
+				}
+				r.speedup, r.serial = math.NaN(), math.NaN()
+				if base := results[first:]; len(base) > 0 && base[0].elapsed > 0 && r.elapsed > 0 {
+					r.speedup = float64(base[0].elapsed) / float64(r.elapsed)
+				} else if len(base) == 0 && r.elapsed > 0 {
+					r.speedup = 1
+				}
+				if p := float64(min(procs, workers)); p > 1 && !math.IsNaN(r.speedup) {
+					r.serial = (1/r.speedup - 1/p) / (1 - 1/p)
+				}
+				results = append(results, r)
+			}
+		}
+	}
+	return results, nil
+}
+
+// formatRatio formats a speedup or a fraction, or n/a for NaN.
+func formatRatio(f float64, format string) string {
+	if math.IsNaN(f) {
+		return "n/a"
+	}
+	return fmt.Sprintf(format, f)
+}
+
+// writeBenchTable writes the results as an aligned table.
+func writeBenchTable(w io.Writer, results []benchResult) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "workload\tGOMAXPROCS\tworkers\ttime\tspeedup\tserial")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%v\t%s\t%s\n", r.workload, r.procs, r.workers,
+			r.elapsed.Round(time.Microsecond), formatRatio(r.speedup, "%.2fx"), formatRatio(100*r.serial, "%.0f%%"))
+	}
+	return tw.Flush()
+}
+
+// writeBenchCSV writes the results as CSV, with the time in seconds and the
+// serial fraction between 0 and 1.
+func writeBenchCSV(w io.Writer, results []benchResult) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"workload", "gomaxprocs", "workers", "seconds", "speedup", "serial_fraction"})
+	for _, r := range results {
+		cw.Write([]string{
+			r.workload,
+			strconv.Itoa(r.procs),
+			strconv.Itoa(r.workers),
+			strconv.FormatFloat(r.elapsed.Seconds(), 'f', 6, 64),
+			formatRatio(r.speedup, "%.4f"),
+			formatRatio(r.serial, "%.4f"),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// parseIntList parses a comma separated list of positive integers.
+func parseIntList(s string) ([]int, error) {
+	var list []int
+	for _, f := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid number %q in list %q", f, s)
+		}
+		list = append(list, n)
+	}
+	return list, nil
+}
+
+// defaultProcs returns the powers of 2 below the number of CPUs, and that
+// number, e.g. "1,2,4,6" with 6 CPUs.
+func defaultProcs() string {
+	var list []string
+	for n := 1; n < runtime.NumCPU(); n *= 2 {
+		list = append(list, strconv.Itoa(n))
+	}
+	return strings.Join(append(list, strconv.Itoa(runtime.NumCPU())), ",")
+}
+
+// benchCommand implements "refresher bench". It measures with the real time
+// even with -deterministic, whose clock does not advance while computing.
+func benchCommand(env *Env, args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	procsList := fs.String("procs", defaultProcs(), "comma separated GOMAXPROCS values")
+	workersList := fs.String("workers", "1,2,4,8", "comma separated numbers of worker goroutines")
+	runs := fs.Int("runs", 3, "number of runs of each measurement, of which the fastest is kept")
+	scale := fs.Float64("scale", 1, "multiplier of the size of the workloads")
+	match := fs.String("workload", "", "run only the workloads matching this regular expression: parallelSum, primeSieve, matrixMultiply")
+	csvFile := fs.String("csv", "", "also write the results to this CSV `file`")
+	fs.Parse(args)
+
+	cfg := benchConfig{runs: *runs, scale: *scale}
+	var err error
+	if cfg.procs, err = parseIntList(*procsList); err != nil {
+		return fmt.Errorf("-procs: %v", err)
+	}
+	if cfg.workers, err = parseIntList(*workersList); err != nil {
+		return fmt.Errorf("-workers: %v", err)
+	}
+	re, err := regexp.Compile(*match)
+	if err != nil {
+		return fmt.Errorf("-workload: %v", err)
+	}
+	for _, w := range benchWorkloads {
+		if re.MatchString(w.name) {
+			cfg.workloads = append(cfg.workloads, w)
+		}
+	}
+	if len(cfg.workloads) == 0 {
+		return errors.New("no workload matches -workload")
+	}
+
+	return bench(os.Stdout, cfg, *csvFile)
+}
+
+// bench runs the benchmark and writes its results as a table to w and as CSV
+// to csvFile unless it is empty.
+func bench(w io.Writer, cfg benchConfig, csvFile string) error {
+	fmt.Fprintf(w, "%d CPUs, speedups relative to GOMAXPROCS=%d with %d workers\n", runtime.NumCPU(), cfg.procs[0], cfg.workers[0])
+	results, err := runBench(cfg, time.Now)
+	if err != nil {
+		return err
+	}
+	if err := writeBenchTable(w, results); err != nil {
+		return err
+	}
+	if csvFile == "" {
+		return nil
+	}
+	f, err := os.Create(csvFile)
+	if err != nil {
+		return err
+	}
+	if err := writeBenchCSV(f, results); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWorkloads(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		run  func(n, workers int) int
+		n    int
+		want int
+	}{
+		{"parallelSum", parallelSum, 10, 285},
+		{"primeSieve", primeSieve, 100, 25},
+		{"primeSieve", primeSieve, 2, 0},
+		{"primeSieve", primeSieve, 3, 1},
+		{"primeSieve", primeSieve, 1000, 168},
+		{"matrixMultiply", matrixMultiply, 1, 0},
+		{"matrixMultiply", matrixMultiply, 2, 3},
+	} {
+		for _, workers := range []int{1, 2, 3, 7} {
+			if got := test.run(test.n, workers); got != test.want {
+				t.Errorf("%s(%d, %d) = %d, want %d", test.name, test.n, workers, got, test.want)
+			}
+		}
+	}
+}
+
+// steppingClock returns a clock advancing by the elapsed times in turn at each
+// second call, to time a benchmark.
+func steppingClock(elapsed ...time.Duration) func() time.Time {
+	now, calls := time.Time{}, 0
+	return func() time.Time {
+		if calls%2 == 1 {
+			now = now.Add(elapsed[calls/2%len(elapsed)])
+		}
+		calls++
+		return now
+	}
+}
+
+func TestRunBench(t *testing.T) {
+	cfg := benchConfig{
+		workloads: []benchWorkload{{"sum", 1000, parallelSum}},
+		procs:     []int{1, 2},
+		workers:   []int{1, 2},
+		runs:      1,
+		scale:     1,
+	}
+	results, err := runBench(cfg, steppingClock(4*time.Second, 4*time.Second, 4*time.Second, 2500*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("got %d results, want 4", len(results))
+	}
+	var buf bytes.Buffer
+	if err := writeBenchTable(&buf, results); err != nil {
+		t.Fatal(err)
+	}
+	want := `workload  GOMAXPROCS  workers  time  speedup  serial
+sum       1           1        4s    1.00x    n/a
+sum       1           2        4s    1.00x    n/a
+sum       2           1        4s    1.00x    n/a
+sum       2           2        2.5s  1.60x    25%
+`
+	if got := buf.String(); got != want {
+		t.Errorf("table:\n%s\nwant:\n%s", got, want)
+	}
+
+	buf.Reset()
+	if err := writeBenchCSV(&buf, results); err != nil {
+		t.Fatal(err)
+	}
+	want = `workload,gomaxprocs,workers,seconds,speedup,serial_fraction
+sum,1,1,4.000000,1.0000,n/a
+sum,1,2,4.000000,1.0000,n/a
+sum,2,1,4.000000,1.0000,n/a
+sum,2,2,2.500000,1.6000,0.2500
+`
+	if got := buf.String(); got != want {
+		t.Errorf("CSV:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRunBenchBestRun(t *testing.T) {
+	cfg := benchConfig{
+		workloads: []benchWorkload{{"sum", 1000, parallelSum}},
+		procs:     []int{1},
+		workers:   []int{1},
+		runs:      3,
+		scale:     1,
+	}
+	results, err := runBench(cfg, steppingClock(3*time.Second, time.Second, 2*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := results[0].elapsed; got != time.Second {
+		t.Errorf("got %v, want the best run of 1s", got)
+	}
+}
+
+func TestRunBenchChecksum(t *testing.T) {
+	wrong := func(n, workers int) int { return workers }
+	cfg := benchConfig{
+		workloads: []benchWorkload{{"wrong", 1, wrong}},
+		procs:     []int{1},
+		workers:   []int{1, 2},
+		runs:      1,
+		scale:     1,
+	}
+	_, err := runBench(cfg, time.Now)
+	if err == nil || !strings.Contains(err.Error(), "with 2 workers") {
+		t.Errorf("got error %v, want a checksum mismatch", err)
+	}
+}
+
+func TestRunBenchZeroTime(t *testing.T) {
+	cfg := benchConfig{
+		workloads: benchWorkloads[:1],
+		procs:     []int{1},
+		workers:   []int{1, 2},
+		runs:      1,
+		scale:     1e-6,
+	}
+	results, err := runBench(cfg, func() time.Time { return time.Time{} })
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		if !math.IsNaN(r.speedup) || !math.IsNaN(r.serial) {
+			t.Errorf("%+v: want NaN speedup and serial fraction without time", r)
+		}
+	}
+}
+
+func TestParseIntList(t *testing.T) {
+	for _, test := range []struct {
+		s    string
+		want []int
+	}{
+		{"1", []int{1}},
+		{"1,2, 4", []int{1, 2, 4}},
+		{"", nil},
+		{"1,,2", nil},
+		{"0", nil},
+		{"x", nil},
+	} {
+		got, err := parseIntList(test.s)
+		if !reflect.DeepEqual(got, test.want) || (err == nil) != (test.want != nil) {
+			t.Errorf("parseIntList(%q) = %v, %v, want %v", test.s, got, err, test.want)
+		}
+	}
+}
+
+func TestBench(t *testing.T) {
+	csvFile := filepath.Join(t.TempDir(), "bench.csv")
+	cfg := benchConfig{
+		workloads: benchWorkloads[1:2],
+		procs:     []int{1, 2},
+		workers:   []int{1, 2},
+		runs:      1,
+		scale:     0.001,
+	}
+	var out bytes.Buffer
+	if err := bench(&out, cfg, csvFile); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(out.String(), "\nprimeSieve "); got != 4 {
+		t.Errorf("got %d table rows, want 4:\n%s", got, out.String())
+	}
+	data, err := os.ReadFile(csvFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 5 || !strings.HasPrefix(lines[4], "primeSieve,2,2,") {
+		t.Errorf("got CSV:\n%s\nwant a header and 4 primeSieve rows", data)
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// The cat and wc commands grow the cat and catBuffered helpers of
+// communicationInGo into utilities which behave like GNU cat and wc
+// (coreutils 9.1, in a UTF-8 locale) on the files they share: they read any
+// number of files, "-" being stdin, report the files they cannot read and go
+// on with the next ones. They stream their input through a fixed buffer, with
+// the loops of the helpers, readChunks for cat and readLines for wc, so that
+// their memory does not depend on the size of the files.
+
+// errReported is returned by a command which already reported its failure to
+// the user, so that main only sets the exit status.
+var errReported = errors.New("failure already reported")
+
+// commandInputs opens the files named on the command line of cat and wc in the
+// filesystem selected by -fs.
+type commandInputs struct {
+	fsys  fs.FS
+	spec  string // the -fs flag, see fsName
+	stdin io.Reader
+}
+
+// newCommandInputs returns the inputs of a command run from the command line.
+func newCommandInputs() (commandInputs, error) {
+	fsys, err := openFS(*fileSystem)
+	if err != nil {
+		return commandInputs{}, err
+	}
+	return commandInputs{fsys, *fileSystem, os.Stdin}, nil
+}
+
+// open opens the named file, or stdin for "-".
+func (in commandInputs) open(name string) (io.ReadCloser, error) {
+	if name == "-" {
+		return io.NopCloser(in.stdin), nil
+	}
+	fsname, err := fsName(in.spec, name)
+	if err != nil {
+		return nil, err
+	}
+	return in.fsys.Open(fsname)
+}
+
+// size returns the size of the named file, or stdin for "-", and whether it
+// is a regular file. It returns an error if it cannot tell.
+func (in commandInputs) size(name string) (int64, bool, error) {
+	var info fs.FileInfo
+	var err error
+	if name == "-" {
+		f, ok := in.stdin.(*os.File)
+		if !ok {
+			return 0, false, nil // such as a pipe
+		}
+		info, err = f.Stat()
+	} else {
+		var fsname string
+		if fsname, err = fsName(in.spec, name); err == nil {
+			info, err = fs.Stat(in.fsys, fsname)
+		}
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return info.Size(), info.Mode().IsRegular(), nil
+}
+
+// errorText returns the message of an error reading a file as the C library
+// would describe it, e.g. "No such file or directory", without the operation
+// and the file name which Go adds.
+func errorText(err error) string {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return "No such file or directory"
+	case errors.Is(err, fs.ErrPermission):
+		return "Permission denied"
+	}
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		err = pathErr.Err
+	}
+	msg := err.Error()
+	return strings.ToUpper(msg[:1]) + msg[1:]
+}
+
+// gnuOption is an option of cat or wc, which takes no argument.
+type gnuOption struct {
+	short byte   // the letter of the short option, such as 'n' for -n
+	long  string // the name of the long option, such as "number" for --number
+	usage string
+	set   func()
+}
+
+// parseOptions parses the command line args of the command name the way GNU
+// getopt_long does: short options can be grouped, as in -nE, options can follow
+// the operands, long options can be shortened to a unique prefix, as in --num,
+// and "--" ends the options. It returns the operands.
+//
+// On an invalid option it prints the same message as GNU to stderr and returns
+// errReported. With --help, it prints the usage to stdout and returns
+// flag.ErrHelp.
+func parseOptions(name string, args []string, opts []gnuOption, stdout, stderr io.Writer) ([]string, error) {
+	invalid := func(format string, a ...interface{}) error {
+		fmt.Fprintf(stderr, "%s: %s\n", name, fmt.Sprintf(format, a...))
+		fmt.Fprintf(stderr, "Try '%s --help' for more information.\n", name)
+		return errReported
+	}
+	var operands []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			return append(operands, args[i+1:]...), nil
+		case arg == "--help":
+			fmt.Fprintf(stdout, "Usage: %s [OPTION]... [FILE]...\n", name)
+			for _, o := range opts {
+				fmt.Fprintf(stdout, "  -%c, --%-18s %s\n", o.short, o.long, o.usage)
+			}
+			fmt.Fprintf(stdout, "      --%-18s %s\n", "help", "display this help and exit")
+			return nil, flag.ErrHelp
+		case strings.HasPrefix(arg, "--"):
+			long, _, hasValue := strings.Cut(arg[2:], "=")
+			var matches []gnuOption
+			for _, o := range opts {
+				if o.long == long {
+					matches = []gnuOption{o}
+					break
+				}
+				if strings.HasPrefix(o.long, long) {
+					matches = append(matches, o)
+				}
+			}
+			switch {
+			case len(matches) == 0:
+				return nil, invalid("unrecognized option '%s'", arg)
+			case len(matches) > 1:
+				var names []string
+				for _, o := range matches {
+					names = append(names, "'--"+o.long+"'")
+				}
+				return nil, invalid("option '%s' is ambiguous; possibilities: %s", arg, strings.Join(names, " "))
+			case hasValue:
+				return nil, invalid("option '--%s' doesn't allow an argument", matches[0].long)
+			}
+			matches[0].set()
+		case len(arg) > 1 && arg[0] == '-':
+		letters:
+			for _, c := range []byte(arg[1:]) {
+				for _, o := range opts {
+					if o.short == c {
+						o.set()
+						continue letters
+					}
+				}
+				return nil, invalid("invalid option -- '%c'", c)
+			}
+		default:
+			operands = append(operands, arg) // "-" included
+		}
+	}
+	return operands, nil
+}
+
+// catOptions selects how cat prints its input.
+type catOptions struct {
+	number          bool // -n: number the lines
+	showEnds        bool // -E: print $ at the end of each line
+	showTabs        bool // -T: print tabs as ^I
+	showNonprinting bool // -v: print control characters as ^X and bytes above 127 as M-X
+}
+
+// catWriter prints the inputs of cat one after the other. Like GNU cat, it
+// keeps numbering the lines from one input to the next, and an input which
+// does not end with a newline continues on the line of the next one.
+type catWriter struct {
+	w         *bufio.Writer
+	opts      catOptions
+	line      int  // the number of the next line
+	midLine   bool // the last byte printed was not a newline
+	pendingCR bool // with -E, a carriage return is held until the next byte
+}
+
+func newCatWriter(w io.Writer, opts catOptions) *catWriter {
+	return &catWriter{w: bufio.NewWriter(w), opts: opts, line: 1}
+}
+
+// copy prints r.
+func (c *catWriter) copy(r io.Reader) error {
+	if c.opts == (catOptions{}) {
+		_, err := io.Copy(c.w, r)
+		return err
+	}
+	_, err := readChunks(r, make([]byte, 32*1024), func(chunk []byte) {
+		for _, b := range chunk {
+			c.writeByte(b)
+		}
+	})
+	return err
+}
+
+// writeByte prints a byte of the input, preceded by a line number at the start
+// of a line. With -E, the carriage return of a CRLF line ending is shown as
+// ^M, even if the line feed is at the start of the next input.
+func (c *catWriter) writeByte(b byte) {
+	if c.opts.number && !c.midLine {
+		fmt.Fprintf(c.w, "%6d\t", c.line)
+		c.line++
+	}
+	c.midLine = b != '\n'
+	if c.pendingCR {
+		c.pendingCR = false
+		if b == '\n' {
+			c.w.WriteString("^M")
+		} else {
+			c.writeChar('\r')
+		}
+	}
+	if b == '\r' && c.opts.showEnds {
+		c.pendingCR = true
+		return
+	}
+	c.writeChar(b)
+}
+
+// writeChar prints a byte as selected by the options.
+func (c *catWriter) writeChar(b byte) {
+	switch {
+	case b == '\n':
+		if c.opts.showEnds {
+			c.w.WriteByte('$')
+		}
+		c.w.WriteByte(b)
+	case b == '\t':
+		if c.opts.showTabs {
+			c.w.WriteString("^I")
+		} else {
+			c.w.WriteByte(b)
+		}
+	case c.opts.showNonprinting:
+		if b >= 128 {
+			c.w.WriteString("M-")
+			b -= 128
+		}
+		switch {
+		case b < ' ':
+			c.w.WriteByte('^')
+			c.w.WriteByte(b + '@')
+		case b == 127:
+			c.w.WriteString("^?")
+		default:
+			c.w.WriteByte(b)
+		}
+	default:
+		c.w.WriteByte(b)
+	}
+}
+
+// finish prints a held carriage return, at the end of the last input, and
+// flushes the output.
+func (c *catWriter) finish() error {
+	if c.pendingCR {
+		c.pendingCR = false
+		c.writeChar('\r')
+	}
+	return c.w.Flush()
+}
+
+// catFiles prints the named files, or stdin if there are none, to stdout and
+// reports the files it cannot read to stderr. It returns errReported if there
+// were any, or the error writing to stdout.
+func catFiles(in commandInputs, names []string, opts catOptions, stdout, stderr io.Writer) error {
+	if len(names) == 0 {
+		names = []string{"-"}
+	}
+	c := newCatWriter(stdout, opts)
+	failed := false
+	for _, name := range names {
+		f, err := in.open(name)
+		if err == nil {
+			err = c.copy(f)
+			f.Close()
+		}
+		if err != nil {
+			// Print what was read before the error message.
+			if err := c.w.Flush(); err != nil {
+				return err
+			}
+			fmt.Fprintf(stderr, "cat: %s: %s\n", name, errorText(err))
+			failed = true
+		}
+	}
+	if err := c.finish(); err != nil {
+		return err
+	}
+	if failed {
+		return errReported
+	}
+	return nil
+}
+
+// catCommand implements "refresher cat".
+func catCommand(env *Env, args []string) error {
+	in, err := newCommandInputs()
+	if err != nil {
+		return err
+	}
+	defer closeFS(in.fsys)
+	return runCat(in, args, os.Stdout, os.Stderr)
+}
+
+// runCat runs cat with the command line args.
+func runCat(in commandInputs, args []string, stdout, stderr io.Writer) error {
+	var opts catOptions
+	files, err := parseOptions("cat", args, []gnuOption{
+		{'v', "show-nonprinting", "use ^ and M- notation, except for LFD and TAB", func() { opts.showNonprinting = true }},
+		{'E', "show-ends", "display $ at end of each line", func() { opts.showEnds = true }},
+		{'T', "show-tabs", "display TAB characters as ^I", func() { opts.showTabs = true }},
+		{'A', "show-all", "equivalent to -vET", func() { opts.showNonprinting, opts.showEnds, opts.showTabs = true, true, true }},
+		{'n', "number", "number all output lines", func() { opts.number = true }},
+	}, stdout, stderr)
+	if err == flag.ErrHelp {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return catFiles(in, files, opts, stdout, stderr)
+}
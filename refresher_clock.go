@@ -0,0 +1,261 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/fakhir/learngo/internal/stacks"
+)
+
+// Clock tells the time, sleeps and makes timers. Sections must use it instead
+// of the time package so that a run can be made reproducible.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	After(d time.Duration) <-chan time.Time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer is a *time.Timer of a Clock, whose channel is returned by C.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Ticker is a *time.Ticker of a Clock, whose channel is returned by C.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+	Reset(d time.Duration)
+}
+
+// realClock is the Clock of the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) NewTimer(d time.Duration) Timer         { return realTimer{time.NewTimer(d)} }
+func (realClock) NewTicker(d time.Duration) Ticker       { return realTicker{time.NewTicker(d)} }
+
+type realTimer struct{ t *time.Timer }
+
+func (t realTimer) C() <-chan time.Time        { return t.t.C }
+func (t realTimer) Stop() bool                 { return t.t.Stop() }
+func (t realTimer) Reset(d time.Duration) bool { return t.t.Reset(d) }
+
+type realTicker struct{ t *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time   { return t.t.C }
+func (t realTicker) Stop()                 { t.t.Stop() }
+func (t realTicker) Reset(d time.Duration) { t.t.Reset(d) }
+
+// virtualQuantum is how often an auto-advancing virtualClock checks, in real
+// time, whether it can move to its next timer.
+const virtualQuantum = 2 * time.Millisecond
+
+// virtualClock is a Clock whose time only moves when it is advanced, so that
+// nothing waits for real and timers fire in a reproducible order: sleeping
+// 1s and 2s in two goroutines always wakes the first one first.
+//
+// An auto-advancing clock advances by itself. Once its timers have not
+// changed for virtualQuantum and no other goroutine is running or ready to
+// run, it assumes that the goroutines are blocked on the timers or on each
+// other, and moves to the time of the next timer. A goroutine which computes
+// before its next Sleep thus finds the clock where it left it, however loaded
+// the machine is. A goroutine waiting for a system call or the network is
+// not waited for, though: a section which mixes sleeps with, say, commands
+// or HTTP requests does not run the same way every time. Otherwise, the clock
+// is advanced by calling Advance.
+type virtualClock struct {
+	auto bool
+
+	mu      sync.Mutex
+	now     time.Time
+	timers  []*virtualTimer // active timers, in no particular order
+	changes int             // incremented whenever the timers change
+	running bool            // the auto-advance goroutine is running
+}
+
+// newVirtualClock returns a virtual clock set to now.
+func newVirtualClock(now time.Time, auto bool) *virtualClock {
+	return &virtualClock{auto: auto, now: now}
+}
+
+func (c *virtualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *virtualClock) Sleep(d time.Duration) {
+	<-c.NewTimer(d).C()
+}
+
+func (c *virtualClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+func (c *virtualClock) NewTimer(d time.Duration) Timer {
+	t := &virtualTimer{clock: c, c: make(chan time.Time, 1)}
+	c.schedule(t, d, 0)
+	return t
+}
+
+func (c *virtualClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	t := &virtualTimer{clock: c, c: make(chan time.Time, 1)}
+	c.schedule(t, d, d)
+	return virtualTicker{t}
+}
+
+// Advance moves the clock forward by d, firing the timers due in between in
+// order.
+func (c *virtualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.advanceTo(c.now.Add(d))
+}
+
+// schedule arms t to fire after d, then every period unless it is zero, and
+// reports whether t was armed before. It starts the auto-advance goroutine if
+// needed.
+func (c *virtualClock) schedule(t *virtualTimer, d, period time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	armed := c.remove(t)
+	t.when, t.period = c.now.Add(d), period
+	c.timers = append(c.timers, t)
+	c.changes++
+	if d <= 0 {
+		c.advanceTo(c.now)
+	}
+	if c.auto && !c.running && len(c.timers) > 0 {
+		c.running = true
+		go c.autoAdvance()
+	}
+	return armed
+}
+
+// remove disarms t and reports whether it was armed.
+func (c *virtualClock) remove(t *virtualTimer) bool {
+	for i, other := range c.timers {
+		if other == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			c.changes++
+			return true
+		}
+	}
+	return false
+}
+
+// next returns the timer which fires first, or nil if there are none.
+func (c *virtualClock) next() *virtualTimer {
+	var first *virtualTimer
+	for _, t := range c.timers {
+		if first == nil || t.when.Before(first.when) {
+			first = t
+		}
+	}
+	return first
+}
+
+// advanceTo fires the timers due by target in order, then sets the time to
+// target. Like those of the time package, a timer whose channel is full drops
+// the tick.
+func (c *virtualClock) advanceTo(target time.Time) {
+	for t := c.next(); t != nil && !t.when.After(target); t = c.next() {
+		if t.when.After(c.now) {
+			c.now = t.when
+		}
+		select {
+		case t.c <- c.now:
+		default:
+		}
+		if t.period > 0 {
+			t.when = t.when.Add(t.period)
+			c.changes++
+		} else {
+			c.remove(t)
+		}
+	}
+	if target.After(c.now) {
+		c.now = target
+	}
+}
+
+// autoAdvance moves the clock to the next timer whenever the timers have not
+// changed for virtualQuantum and the other goroutines are blocked, until there
+// are no timers left.
+func (c *virtualClock) autoAdvance() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.timers) > 0 {
+		seen := c.changes
+		c.mu.Unlock()
+		time.Sleep(virtualQuantum)
+		busy := othersBusy()
+		c.mu.Lock()
+		if c.changes == seen && !busy {
+			if t := c.next(); t != nil {
+				c.advanceTo(t.when)
+			}
+		}
+	}
+	c.running = false
+}
+
+// busyStates are the states of goroutines which are running or ready to run.
+var busyStates = map[string]bool{
+	"running":   true,
+	"runnable":  true,
+	"preempted": true,
+}
+
+// othersBusy reports whether a goroutine other than the one calling it is
+// running or ready to run, and so may still set a timer.
+func othersBusy() bool {
+	// The stack of the calling goroutine comes first.
+	for _, g := range stacks.All()[1:] {
+		if busyStates[g.State] {
+			return true
+		}
+	}
+	return false
+}
+
+// virtualTimer is a timer of a virtualClock, also used by virtualTicker.
+type virtualTimer struct {
+	clock  *virtualClock
+	c      chan time.Time
+	when   time.Time
+	period time.Duration
+}
+
+func (t *virtualTimer) C() <-chan time.Time { return t.c }
+
+func (t *virtualTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	return t.clock.remove(t)
+}
+
+func (t *virtualTimer) Reset(d time.Duration) bool {
+	return t.clock.schedule(t, d, 0)
+}
+
+type virtualTicker struct{ t *virtualTimer }
+
+func (t virtualTicker) C() <-chan time.Time { return t.t.c }
+func (t virtualTicker) Stop()               { t.t.Stop() }
+
+func (t virtualTicker) Reset(d time.Duration) {
+	if d <= 0 {
+		panic("non-positive interval for Ticker.Reset")
+	}
+	t.t.clock.schedule(t.t, d, d)
+}
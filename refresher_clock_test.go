@@ -0,0 +1,126 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fakhir/learngo/internal/leaktest"
+)
+
+// received returns the value waiting in ch, if any.
+func received(ch <-chan time.Time) (time.Time, bool) {
+	select {
+	case v := <-ch:
+		return v, true
+	default:
+		return time.Time{}, false
+	}
+}
+
+func TestVirtualClockAdvance(t *testing.T) {
+	c := newVirtualClock(deterministicNow, false)
+	timer := c.NewTimer(2 * time.Second)
+	stopped := c.NewTimer(time.Second)
+	ticker := c.NewTicker(time.Second)
+	if !stopped.Stop() || stopped.Stop() {
+		t.Error("Stop did not report whether the timer was armed")
+	}
+
+	c.Advance(1500 * time.Millisecond)
+	if got := c.Now(); !got.Equal(deterministicNow.Add(1500 * time.Millisecond)) {
+		t.Errorf("Now after Advance: got %v", got)
+	}
+	if v, ok := received(ticker.C()); !ok || !v.Equal(deterministicNow.Add(time.Second)) {
+		t.Errorf("ticker: got %v, %v", v, ok)
+	}
+	if _, ok := received(timer.C()); ok {
+		t.Error("timer fired early")
+	}
+	if _, ok := received(stopped.C()); ok {
+		t.Error("stopped timer fired")
+	}
+
+	c.Advance(time.Second)
+	if v, ok := received(timer.C()); !ok || !v.Equal(deterministicNow.Add(2*time.Second)) {
+		t.Errorf("timer: got %v, %v", v, ok)
+	}
+	if timer.Reset(time.Second) {
+		t.Error("Reset reported that the fired timer was armed")
+	}
+	ticker.Stop()
+	c.Advance(5 * time.Second)
+	if _, ok := received(timer.C()); !ok {
+		t.Error("reset timer did not fire")
+	}
+	// The ticker dropped the ticks of the full channel, then was stopped.
+	if _, ok := received(ticker.C()); !ok {
+		t.Error("the tick of 2s was not kept")
+	}
+	if _, ok := received(ticker.C()); ok {
+		t.Error("stopped ticker fired")
+	}
+}
+
+func TestVirtualClockAuto(t *testing.T) {
+	leaktest.Check(t)
+	c := newVirtualClock(deterministicNow, true)
+	start := time.Now()
+	woke := make(chan time.Duration, 3)
+	for _, d := range []time.Duration{3 * time.Hour, time.Hour, 2 * time.Hour} {
+		go func() {
+			c.Sleep(d)
+			woke <- d
+		}()
+	}
+	for _, want := range []time.Duration{time.Hour, 2 * time.Hour, 3 * time.Hour} {
+		if d := <-woke; d != want {
+			t.Errorf("woke up after %v, want %v", d, want)
+		}
+	}
+	if got := c.Now(); !got.Equal(deterministicNow.Add(3 * time.Hour)) {
+		t.Errorf("Now: got %v", got)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("sleeping 3 virtual hours took %v", elapsed)
+	}
+}
+
+// TestVirtualClockWaitsForWork checks that the clock does not advance while a
+// goroutine computes between two sleeps, however long it takes.
+func TestVirtualClockWaitsForWork(t *testing.T) {
+	leaktest.Check(t)
+	c := newVirtualClock(deterministicNow, true)
+	woke := make(chan time.Time)
+	go func() {
+		c.Sleep(time.Hour)
+		for start := time.Now(); time.Since(start) < 50*time.Millisecond; {
+		}
+		c.Sleep(time.Hour)
+		woke <- c.Now()
+	}()
+	go c.Sleep(90 * time.Minute)
+	if got := <-woke; !got.Equal(deterministicNow.Add(2 * time.Hour)) {
+		t.Errorf("woke up at %v, want 2h later", got.Sub(deterministicNow))
+	}
+}
+
+// TestConcurrencyAndChannelsIsInstant checks that the tea and coffee of the
+// section are ready in order without waiting their 2 seconds.
+func TestConcurrencyAndChannelsIsInstant(t *testing.T) {
+	var out strings.Builder
+	env := newDeterministicEnv(deterministicSeed, deterministicNow)
+	env.out = &out
+	start := time.Now()
+	concurrencyAndChannels(env)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("the section took %v", elapsed)
+	}
+	coffee, tea := strings.Index(out.String(), "Coffee is ready"), strings.Index(out.String(), "Tea is ready")
+	if coffee == -1 || tea < coffee {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+	if got := env.clock.Now(); !got.Equal(deterministicNow.Add(2 * time.Second)) {
+		t.Errorf("the clock is at %v, want 2s later", got)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var coreutils = flag.Bool("coreutils", false, "update the expected output of cat and wc in testdata/coreutils by running GNU coreutils 9.1")
+
+// coreutilsCases are run by both the cat and wc commands and by GNU coreutils
+// in testdata/coreutils/inputs, with the content of the stdin file, if any,
+// piped to their standard input. Their expected standard output and error are
+// testdata/coreutils/<name>.stdout and .stderr.
+var coreutilsCases = []struct {
+	name  string
+	args  []string
+	stdin string
+}{
+	{name: "cat", args: []string{"cat", "plain.txt"}},
+	{name: "cat-files", args: []string{"cat", "plain.txt", "noeol.txt", "utf8.txt", "empty", "noeol.txt"}},
+	{name: "cat-n", args: []string{"cat", "-n", "plain.txt", "noeol.txt", "plain.txt", "empty", "noeol.txt"}},
+	{name: "cat-n-long", args: []string{"cat", "-n", "long.txt"}},
+	{name: "cat-A", args: []string{"cat", "-A", "binary.bin", "crlf.txt", "utf8.txt"}},
+	{name: "cat-n-A", args: []string{"cat", "-n", "-A", "noeol.txt", "binary.bin"}},
+	{name: "cat-E", args: []string{"cat", "-E", "crlf.txt", "plain.txt"}},
+	{name: "cat-E-split", args: []string{"cat", "-E", "cr-end.txt", "lf-start.txt", "cr-end.txt"}},
+	{name: "cat-v-cr", args: []string{"cat", "-v", "-E", "crlf.txt", "lf-start.txt"}},
+	{name: "cat-T", args: []string{"cat", "-T", "plain.txt", "binary.bin"}},
+	{name: "cat-v", args: []string{"cat", "-v", "binary.bin"}},
+	{name: "cat-stdin", args: []string{"cat"}, stdin: "utf8.txt"},
+	{name: "cat-n-dash", args: []string{"cat", "-n", "noeol.txt", "-", "noeol.txt"}, stdin: "plain.txt"},
+	{name: "cat-errors", args: []string{"cat", "-n", "noeol.txt", "nosuch", "dir", "plain.txt"}},
+	{name: "cat-grouped", args: []string{"cat", "-nA", "noeol.txt", "binary.bin"}},
+	{name: "cat-permuted", args: []string{"cat", "noeol.txt", "-n", "binary.bin", "-A"}},
+	{name: "cat-dash-dash", args: []string{"cat", "-n", "--", "noeol.txt", "-A"}},
+	{name: "cat-long", args: []string{"cat", "--number", "--show-e", "crlf.txt"}},
+	{name: "cat-invalid", args: []string{"cat", "-nx", "plain.txt"}},
+	{name: "cat-ambiguous", args: []string{"cat", "--show", "plain.txt"}},
+	{name: "cat-unrecognized", args: []string{"cat", "--shout", "plain.txt"}},
+	{name: "wc", args: []string{"wc", "plain.txt"}},
+	{name: "wc-files", args: []string{"wc", "plain.txt", "noeol.txt", "utf8.txt", "binary.bin", "crlf.txt", "empty", "long.txt"}},
+	{name: "wc-l", args: []string{"wc", "-l", "plain.txt"}},
+	{name: "wc-w", args: []string{"wc", "-w", "utf8.txt"}},
+	{name: "wc-m", args: []string{"wc", "-m", "utf8.txt", "binary.bin", "long.txt"}},
+	{name: "wc-c", args: []string{"wc", "-c", "long.txt", "empty"}},
+	{name: "wc-edge-utf8", args: []string{"wc", "-lwmc", "edge-utf8.bin"}},
+	{name: "wc-all", args: []string{"wc", "-l", "-w", "-m", "-c", "utf8.txt", "binary.bin", "crlf.txt", "dir/inner.txt"}},
+	{name: "wc-empty", args: []string{"wc", "empty"}},
+	{name: "wc-stdin", args: []string{"wc"}, stdin: "utf8.txt"},
+	{name: "wc-l-stdin", args: []string{"wc", "-l"}, stdin: "long.txt"},
+	{name: "wc-dash", args: []string{"wc", "-c", "plain.txt", "-"}, stdin: "noeol.txt"},
+	{name: "wc-errors", args: []string{"wc", "nosuch", "plain.txt", "dir"}},
+	{name: "wc-l-errors", args: []string{"wc", "-l", "nosuch", "noeol.txt"}},
+	{name: "wc-grouped", args: []string{"wc", "-lw", "plain.txt", "utf8.txt"}},
+	{name: "wc-permuted", args: []string{"wc", "plain.txt", "-c", "utf8.txt"}},
+	{name: "wc-long", args: []string{"wc", "--lines", "--wo", "utf8.txt"}},
+	{name: "wc-argument", args: []string{"wc", "--bytes=2", "plain.txt"}},
+	{name: "wc-invalid", args: []string{"wc", "-x", "plain.txt"}},
+}
+
+// TestCoreutils checks that the cat and wc commands print the same as GNU cat
+// and wc. Update the expected output on a machine with GNU coreutils 9.1 with
+// $ go test -run TestCoreutils -coreutils
+func TestCoreutils(t *testing.T) {
+	dir := filepath.Join("testdata", "coreutils")
+	inputs := filepath.Join(dir, "inputs")
+	if *coreutils {
+		out, err := exec.Command("wc", "--version").Output()
+		if err != nil || !strings.Contains(string(out), "GNU coreutils) 9.1") {
+			t.Fatalf("not GNU coreutils 9.1: %s %v", out, err)
+		}
+	}
+	for _, c := range coreutilsCases {
+		t.Run(c.name, func(t *testing.T) {
+			var stdin []byte
+			if c.stdin != "" {
+				var err error
+				if stdin, err = os.ReadFile(filepath.Join(inputs, c.stdin)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			stdoutFile := filepath.Join(dir, c.name+".stdout")
+			stderrFile := filepath.Join(dir, c.name+".stderr")
+
+			if *coreutils {
+				var stdout, stderr bytes.Buffer
+				cmd := exec.Command(c.args[0], c.args[1:]...)
+				cmd.Dir = inputs
+				cmd.Env = append(os.Environ(), "LC_ALL=C.UTF-8")
+				cmd.Stdin = bytes.NewReader(stdin) // a pipe, not a regular file
+				cmd.Stdout, cmd.Stderr = &stdout, &stderr
+				err := cmd.Run()
+				if _, ok := err.(*exec.ExitError); err != nil && !ok {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(stdoutFile, stdout.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+				os.Remove(stderrFile)
+				if stderr.Len() > 0 {
+					if err := os.WriteFile(stderrFile, stderr.Bytes(), 0644); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			wantStdout, err := os.ReadFile(stdoutFile)
+			if err != nil {
+				t.Fatalf("%v (run with -coreutils to create it)", err)
+			}
+			wantStderr, err := os.ReadFile(stderrFile)
+			if err != nil && !os.IsNotExist(err) {
+				t.Fatal(err)
+			}
+
+			// The names are relative to the root of the filesystem, like the
+			// relative names of the OS filesystem are relative to the
+			// current directory.
+			in := commandInputs{fsys: os.DirFS(inputs), stdin: bytes.NewReader(stdin)}
+			run := map[string]func(commandInputs, []string, io.Writer, io.Writer) error{"cat": runCat, "wc": runWC}[c.args[0]]
+			var stdout, stderr bytes.Buffer
+			err = run(in, c.args[1:], &stdout, &stderr)
+			if failed := len(wantStderr) > 0; (err == errReported) != failed || err != nil && err != errReported {
+				t.Errorf("got error %v, want a failure: %v", err, failed)
+			}
+			if !bytes.Equal(stdout.Bytes(), wantStdout) {
+				t.Errorf("stdout differs from %s:\n%s\nwant:\n%s", stdoutFile, stdout.Bytes(), wantStdout)
+			}
+			if !bytes.Equal(stderr.Bytes(), wantStderr) {
+				t.Errorf("stderr:\n%s\nwant:\n%s", stderr.Bytes(), wantStderr)
+			}
+		})
+	}
+}
+
+// oneByteReader returns the bytes of its reader one at a time, to split every
+// character across reads.
+type oneByteReader struct {
+	r io.Reader
+}
+
+func (r oneByteReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return r.r.Read(p[:1])
+}
+
+// TestCountWCStreaming checks that the counts do not depend on how the input is
+// split into reads.
+func TestCountWCStreaming(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "coreutils", "inputs", "utf8.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := countWC(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := countWC(oneByteReader{bytes.NewReader(data)})
+	if err != nil || got != want {
+		t.Errorf("got %+v, %v, want %+v", got, err, want)
+	}
+}
+
+// TestCountWCLongLine checks the counts of a line longer than the buffer,
+// split in the middle of a character.
+func TestCountWCLongLine(t *testing.T) {
+	line := "a" + strings.Repeat("é", 40000) + "\n"
+	got, err := countWC(strings.NewReader(line))
+	want := wcCounts{lines: 1, words: 1, chars: 40002, bytes: int64(len(line))}
+	if err != nil || got != want {
+		t.Errorf("got %+v, %v, want %+v", got, err, want)
+	}
+}
+
+// TestCatWriterStreaming checks that cat continues a line, and a CRLF line
+// ending, across reads and inputs.
+func TestCatWriterStreaming(t *testing.T) {
+	var out bytes.Buffer
+	c := newCatWriter(&out, catOptions{number: true, showEnds: true})
+	for _, input := range []string{"ab\r\ncd\r", "\nef\r"} {
+		if err := c.copy(oneByteReader{strings.NewReader(input)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.finish(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "     1\tab^M$\n     2\tcd^M$\n     3\tef\r"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
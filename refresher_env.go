@@ -0,0 +1,121 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fakhir/learngo/chantrace"
+)
+
+var (
+	deterministic = flag.Bool("deterministic", false, "make the output reproducible by fixing the clock, random seed, OS name and CPU count")
+	randSeed      = flag.Int64("seed", 0, "seed for the random source (default: the current time, or 1 with -deterministic)")
+	fixedNow      = flag.String("now", "", "start a virtual clock at this time, in RFC 3339 format: it only advances when sections sleep, without waiting (default: the real clock, or "+deterministicNow.Format(time.RFC3339)+" with -deterministic)")
+)
+
+// The values used by a deterministic environment unless overridden by flags.
+var (
+	deterministicNow  = time.Date(2020, time.February, 22, 9, 30, 0, 0, time.UTC)
+	deterministicSeed = int64(1)
+)
+
+// Env is the environment passed to every section. It supplies the values
+// which would otherwise make the output differ between runs and machines, and
+// receives everything the section prints (see refresher_output.go).
+type Env struct {
+	clock    Clock
+	rand     *rand.Rand
+	goos     string
+	maxProcs int
+
+	mu      sync.Mutex // serializes output of concurrent goroutines
+	out     io.Writer
+	json    bool
+	section string  // name of the running section
+	errs    []error // the failures of the running section, see Fail
+
+	showSource bool
+	source     *sourceView      // of the running section, with showSource
+	onLine     func(sourceLine) // receives the output instead of out, with showSource
+
+	trace *chantrace.Tracer // records channel operations, with -trace
+
+	progress atomic.Int64 // number of prints, watched by runWatched
+}
+
+// newEnv returns the environment of the machine the refresher runs on.
+func newEnv() *Env {
+	return &Env{
+		clock:    realClock{},
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		goos:     runtime.GOOS,
+		maxProcs: runtime.GOMAXPROCS(0),
+		out:      os.Stdout,
+		section:  "main",
+	}
+}
+
+// newDeterministicEnv returns an environment which produces the same output
+// on every run and every machine.
+func newDeterministicEnv(seed int64, now time.Time) *Env {
+	return &Env{
+		clock:    newVirtualClock(now, true),
+		rand:     rand.New(rand.NewSource(seed)),
+		goos:     "linux",
+		maxProcs: 4,
+		out:      os.Stdout,
+		section:  "main",
+	}
+}
+
+// envFromFlags builds the environment selected by -deterministic, -seed,
+// -now, -format, -show-source and -trace. The -seed and -now flags can also be used on their own.
+func envFromFlags() (*Env, error) {
+	env := newEnv()
+	if *deterministic {
+		env = newDeterministicEnv(deterministicSeed, deterministicNow)
+	}
+	switch *outputFormat {
+	case "text":
+	case "json":
+		env.json = true
+	default:
+		return nil, fmt.Errorf("invalid -format %q: must be text or json", *outputFormat)
+	}
+	env.showSource = *showSource
+	trace, err := newTracer()
+	if err != nil {
+		return nil, err
+	}
+	env.trace = trace
+	if isFlagSet("seed") {
+		env.rand = rand.New(rand.NewSource(*randSeed))
+	}
+	if *fixedNow != "" {
+		now, err := time.Parse(time.RFC3339, *fixedNow)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -now: %v", err)
+		}
+		env.clock = newVirtualClock(now, true)
+	}
+	return env, nil
+}
+
+// isFlagSet reports whether the named flag was given on the command line, which
+// tells a flag set to its zero value, such as -seed 0, from an unset one.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"embed"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing/fstest"
+)
+
+var fileSystem = flag.String("fs", "os", "filesystem of the files read by communicationInGo: os, sample (embedded) or a .zip, .tar or .tar.gz archive")
+
+// sampleFiles holds the files read by communicationInGo, at the same paths as
+// on a Unix system, so that it prints the same on every machine with -fs
+// sample.
+//
+//go:embed sample
+var sampleFiles embed.FS
+
+// sampleFS is the sample directory as the root of a filesystem.
+var sampleFS, _ = fs.Sub(sampleFiles, "sample")
+
+// openFS returns the filesystem selected by -fs. The filesystem of an archive
+// must be closed once read if it is an io.Closer.
+func openFS(spec string) (fs.FS, error) {
+	switch {
+	case spec == "os":
+		// The names in an fs.FS are relative to its root, see fsName.
+		return os.DirFS("/"), nil
+	case spec == "sample":
+		return sampleFS, nil
+	case strings.HasSuffix(spec, ".zip"):
+		// A *zip.ReadCloser is an fs.FS.
+		return zip.OpenReader(spec)
+	case strings.HasSuffix(spec, ".tar"), strings.HasSuffix(spec, ".tar.gz"), strings.HasSuffix(spec, ".tgz"):
+		return readTar(spec)
+	}
+	return nil, fmt.Errorf("invalid -fs %q: must be os, sample or a .zip, .tar or .tar.gz file", spec)
+}
+
+// readTar reads the regular files of a tar archive, possibly compressed with
+// gzip, into memory. Unlike a zip archive, a tar archive has no index of its
+// files, so it cannot be read as an fs.FS in place. fstest.MapFS is a complete
+// in-memory fs.FS, despite its package meant for tests.
+func readTar(name string) (fs.FS, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if !strings.HasSuffix(name, ".tar") {
+		if r, err = gzip.NewReader(f); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	files := fstest.MapFS{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue // the directories are implied by the paths of the files
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		files[path.Clean(strings.TrimPrefix(hdr.Name, "/"))] = &fstest.MapFile{
+			Data:    data,
+			Mode:    hdr.FileInfo().Mode(),
+			ModTime: hdr.ModTime,
+		}
+	}
+}
+
+// fsName returns the name in the filesystem selected by -fs of a file given on
+// the command line. The names of an fs.FS are slash-separated and relative to
+// its root, so /etc/hosts is etc/hosts in every filesystem, and a relative
+// name is first made absolute for the OS filesystem.
+func fsName(spec, name string) (string, error) {
+	if spec == "os" {
+		abs, err := filepath.Abs(name)
+		if err != nil {
+			return "", err
+		}
+		name = filepath.ToSlash(abs)
+	}
+	name = path.Clean(strings.TrimPrefix(name, "/"))
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	return name, nil
+}
+
+// closeFS closes fsys if it has a Close method, as the filesystem of a zip
+// archive has.
+func closeFS(fsys fs.FS) error {
+	if c, ok := fsys.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// testFiles are read by cat and catBuffered. The long file takes several reads
+// of cat's 256 byte buffer.
+var testFiles = fstest.MapFS{
+	"empty":         {Data: []byte("")},
+	"hello.txt":     {Data: []byte("hello\nworld\n")},
+	"no-newline":    {Data: []byte("one\ntwo")},
+	"dir/long.txt":  {Data: []byte(strings.Repeat("0123456789abcdef\n", 40))},
+	"dir/blank.txt": {Data: []byte("\n\n\n")},
+}
+
+func TestCatFS(t *testing.T) {
+	for _, test := range []struct {
+		name         string
+		bytes, lines int
+	}{
+		{"empty", 0, 0},
+		{"hello.txt", 12, 2},
+		{"no-newline", 7, 2},
+		{"dir/long.txt", 680, 40},
+		{"dir/blank.txt", 3, 3},
+	} {
+		var out strings.Builder
+		env := newDeterministicEnv(deterministicSeed, deterministicNow)
+		env.out = &out
+		n, err := cat(env, testFiles, test.name)
+		if err != nil || n != test.bytes {
+			t.Errorf("cat(%s) = %d, %v, want %d bytes", test.name, n, err, test.bytes)
+		}
+		if got := strings.ReplaceAll(out.String(), "Comm:cat:", ""); got != string(testFiles[test.name].Data) {
+			t.Errorf("cat(%s) printed %q", test.name, got)
+		}
+		if want := (test.bytes + 255) / 256; strings.Count(out.String(), "Comm:cat:") != want {
+			t.Errorf("cat(%s) printed in %d chunks, want %d", test.name, strings.Count(out.String(), "Comm:cat:"), want)
+		}
+
+		out.Reset()
+		n, err = catBuffered(env, testFiles, test.name)
+		if err != nil || n != test.lines {
+			t.Errorf("catBuffered(%s) = %d, %v, want %d lines", test.name, n, err, test.lines)
+		}
+		if got := strings.Count(out.String(), "Comm:catbuf:"); got != test.lines {
+			t.Errorf("catBuffered(%s) printed %d lines", test.name, got)
+		}
+	}
+	if _, err := catBuffered(newDeterministicEnv(deterministicSeed, deterministicNow), testFiles, "dir"); err == nil {
+		t.Error("catBuffered(dir) did not fail")
+	}
+}
+
+func TestSampleFS(t *testing.T) {
+	if err := fstest.TestFS(sampleFS, "etc/hosts", "etc/resolv.conf"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeArchives writes files as a zip, a tar and a gzipped tar archive in dir
+// and returns their names.
+func writeArchives(t *testing.T, dir string, files map[string]string) []string {
+	var names []string
+	write := func(name string, archive func(w io.Writer) error) {
+		name = filepath.Join(dir, name)
+		f, err := os.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := archive(f); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	writeTar := func(w io.Writer) error {
+		tw := tar.NewWriter(w)
+		tw.WriteHeader(&tar.Header{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755})
+		for name, data := range files {
+			tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data))})
+			tw.Write([]byte(data))
+		}
+		return tw.Close()
+	}
+	write("files.zip", func(w io.Writer) error {
+		zw := zip.NewWriter(w)
+		for name, data := range files {
+			fw, err := zw.Create(name)
+			if err != nil {
+				return err
+			}
+			fw.Write([]byte(data))
+		}
+		return zw.Close()
+	})
+	write("files.tar", writeTar)
+	write("files.tar.gz", func(w io.Writer) error {
+		zw := gzip.NewWriter(w)
+		if err := writeTar(zw); err != nil {
+			return err
+		}
+		return zw.Close()
+	})
+	return names
+}
+
+func TestOpenFS(t *testing.T) {
+	files := map[string]string{
+		"etc/hosts":       "127.0.0.1 localhost\n",
+		"etc/resolv.conf": "nameserver 192.0.2.53\nsearch example.com\n",
+	}
+	for _, name := range writeArchives(t, t.TempDir(), files) {
+		fsys, err := openFS(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := fstest.TestFS(fsys, "etc/hosts", "etc/resolv.conf"); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		env := newDeterministicEnv(deterministicSeed, deterministicNow)
+		env.out = io.Discard
+		if n, err := catBuffered(env, fsys, "etc/resolv.conf"); err != nil || n != 2 {
+			t.Errorf("%s: catBuffered = %d, %v, want 2 lines", name, n, err)
+		}
+		if err := closeFS(fsys); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if _, err := openFS("files.rar"); err == nil {
+		t.Error("openFS accepted a rar archive")
+	}
+	if _, err := openFS(filepath.Join(t.TempDir(), "missing.zip")); err == nil {
+		t.Error("openFS accepted a missing archive")
+	}
+}
+
+func TestFSName(t *testing.T) {
+	for _, test := range []struct {
+		spec, name, want string
+	}{
+		{"sample", "/etc/hosts", "etc/hosts"},
+		{"sample", "etc//hosts", "etc/hosts"},
+		{"files.zip", "notes.txt", "notes.txt"},
+		{"os", "/etc/hosts", "etc/hosts"},
+	} {
+		if got, err := fsName(test.spec, test.name); err != nil || got != test.want {
+			t.Errorf("fsName(%q, %q) = %q, %v, want %q", test.spec, test.name, got, err, test.want)
+		}
+	}
+	if _, err := fsName("sample", "../secret"); err == nil {
+		t.Error("fsName accepted a name outside of the filesystem")
+	}
+
+	// A relative name is in the current directory of the OS filesystem.
+	name, err := fsName("os", "refresher_fs_test.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys, _ := openFS("os")
+	if _, err := fs.Stat(fsys, name); err != nil {
+		t.Error(err)
+	}
+}
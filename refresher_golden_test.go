@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// The output of every section is compared against testdata/<section>.golden.
+// After intentionally changing what a section prints, regenerate the files
+// and review the diff before committing them:
+// $ go test -run TestGolden -update
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// goldenSkip lists the sections whose output cannot be checked and why.
+var goldenSkip = map[string]string{
+	"communicationInGo": "prints local files, a directory listing and a web page",
+	"setupWebserv":      "serves the web UI until the process is interrupted",
+}
+
+// goldenFilter rewrites the parts of a section's output which differ between
+// runs even in a deterministic environment, so they can be compared.
+type goldenFilter struct {
+	re   *regexp.Regexp
+	repl string
+}
+
+// goldenFilters are applied to the output of every section.
+var goldenFilters = []goldenFilter{
+	// Pointers are printed as addresses.
+	{regexp.MustCompile(`0x[0-9a-f]+`), "0xADDR"},
+	// The updates lost by the racy counter of dataRaces depend on the
+	// scheduler, and it does not run with -race.
+	{regexp.MustCompile(`Races:racy: .*`), "Races:racy: RESULT"},
+}
+
+// TestGolden runs each section in a deterministic environment and compares
+// its output with the golden file.
+func TestGolden(t *testing.T) {
+	for _, s := range sections {
+		s := s
+		t.Run(s.name, func(t *testing.T) {
+			if reason, ok := goldenSkip[s.name]; ok {
+				t.Skip(reason)
+			}
+			if s.manual {
+				t.Skip("manual section, see TestWatchdogDeadlocks")
+			}
+			var out strings.Builder
+			env := newDeterministicEnv(deterministicSeed, deterministicNow)
+			env.out = &out
+			if err := s.run(env); err != nil {
+				t.Errorf("the section failed: %v", err)
+			}
+			got := out.String()
+			for _, f := range goldenFilters {
+				got = f.re.ReplaceAllString(got, f.repl)
+			}
+
+			golden := filepath.Join("testdata", s.name+".golden")
+			if *update {
+				// Sub-sections are in a directory named after their section.
+				if err := os.MkdirAll(filepath.Dir(golden), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v (run with -update to create it)", err)
+			}
+			if got != string(want) {
+				t.Errorf("output differs from %s\n--- got:\n%s--- want:\n%s", golden, got, want)
+			}
+		})
+	}
+}
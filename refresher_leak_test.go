@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"io"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fakhir/learngo/internal/leaktest"
+)
+
+// TestLeakingSections checks that the sections flagged as leaking leave a
+// goroutine blocked, as infiniteWriter explains, unlike the other ones.
+func TestLeakingSections(t *testing.T) {
+	for _, s := range sections {
+		if !s.leaks {
+			continue
+		}
+		t.Run(s.name, func(t *testing.T) {
+			before := leaktest.Goroutines()
+			env := newDeterministicEnv(deterministicSeed, deterministicNow)
+			env.out = io.Discard
+			s.run(env)
+			if leaked := leaktest.Leaked(before, 50*time.Millisecond); len(leaked) == 0 {
+				t.Error("no goroutine leaked")
+			}
+		})
+	}
+}
+
+// TestSectionsDoNotLeak runs the sections which start goroutines, except for
+// those which leak on purpose, and checks that none is left running.
+func TestSectionsDoNotLeak(t *testing.T) {
+	for _, s := range sections {
+		if !s.concurrent || s.leaks {
+			continue
+		}
+		t.Run(s.name, func(t *testing.T) {
+			leaktest.Check(t)
+			env := newDeterministicEnv(deterministicSeed, deterministicNow)
+			env.out = io.Discard
+			s.run(env)
+		})
+	}
+}
+
+// setFlag sets the flag to value for the duration of the test.
+func setFlag(t *testing.T, name, value string) {
+	old := flag.Lookup(name).Value.String()
+	if err := flag.Set(name, value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { flag.Set(name, old) })
+}
+
+// TestSetupWebservDoesNotLeak serves a request with setupWebserv, interrupts
+// it like Ctrl-C would and checks that the server goroutines are gone.
+func TestSetupWebservDoesNotLeak(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("a process cannot interrupt itself on windows")
+	}
+	self, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	setFlag(t, "addr", "127.0.0.1:0")
+	setFlag(t, "access-log", "off")
+	setFlag(t, "prompt", "false")
+	leaktest.Check(t)
+
+	r, w := io.Pipe()
+	env := newEnv()
+	env.out = w
+	done := make(chan bool)
+	go func() {
+		setupWebserv(env)
+		w.Close()
+		close(done)
+	}()
+
+	client := &http.Client{Transport: &http.Transport{}}
+	defer client.CloseIdleConnections()
+	var lines []string
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		lines = append(lines, sc.Text())
+		addr, ok := strings.CutPrefix(sc.Text(), "Webserv: listening on ")
+		if !ok {
+			continue
+		}
+		resp, err := client.Get("http://" + addr + "/hello")
+		if err != nil {
+			t.Error(err)
+		} else {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		if err := self.Signal(os.Interrupt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+	if len(lines) == 0 || lines[len(lines)-1] != "Webserv: stopped" {
+		t.Errorf("setupWebserv printed %q", lines)
+	}
+}
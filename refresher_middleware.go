@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+var accessLogFormat = flag.String("access-log", "common", "format of the web server access log on stderr: common, json or off")
+
+// requestIDHeader carries the ID of a request. An ID set by the client, or by
+// a proxy in front of the server, is kept so that logs can be correlated.
+const requestIDHeader = "X-Request-Id"
+
+// middleware wraps a handler to do something before and after it.
+type middleware func(http.Handler) http.Handler
+
+// chain wraps h with the middlewares, the first one being the outermost.
+func chain(h http.Handler, middlewares ...middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+type requestIDKey struct{}
+
+// requestID returns the ID of the request handled with ctx, if any.
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// withRequestID gives every request an ID, which is returned in the response
+// header and made available to the handlers by requestID.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if id == "" {
+			b := make([]byte, 8)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// withRecover turns a panic of a handler into a 500 response, the same way
+// willPanic in panicAndRecover detects panics, instead of letting net/http
+// drop the connection.
+func withRecover(logger *log.Logger) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				if value := recover(); value != nil {
+					if value == http.ErrAbortHandler {
+						panic(value) // meant to abort the response silently
+					}
+					logger.Printf("panic serving %s (request %s): %v\n%s", r.URL, requestID(r.Context()), value, debug.Stack())
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				}
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// accessLogEntry is a line of the access log in the json format.
+type accessLogEntry struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id"`
+	Remote    string    `json:"remote"`
+	Method    string    `json:"method"`
+	URI       string    `json:"uri"`
+	Proto     string    `json:"proto"`
+	Status    int       `json:"status"`
+	Bytes     int       `json:"bytes"`
+	Duration  float64   `json:"duration_ms"`
+}
+
+// withAccessLog logs every request in the Common Log Format used by web
+// servers such as Apache, or as JSON, after it has been handled.
+func withAccessLog(logger *log.Logger, format string) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := &responseRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+
+			host, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				host = r.RemoteAddr
+			}
+			if format == "json" {
+				b, _ := json.Marshal(accessLogEntry{
+					Time:      start,
+					RequestID: requestID(r.Context()),
+					Remote:    host,
+					Method:    r.Method,
+					URI:       r.RequestURI,
+					Proto:     r.Proto,
+					Status:    rec.status,
+					Bytes:     rec.bytes,
+					Duration:  float64(time.Since(start).Microseconds()) / 1000,
+				})
+				logger.Print(string(b))
+				return
+			}
+			user := "-"
+			if u, _, ok := r.BasicAuth(); ok && u != "" {
+				user = u
+			}
+			logger.Printf("%s - %s [%s] %q %d %d", host, user, start.Format("02/Jan/2006:15:04:05 -0700"),
+				r.Method+" "+r.RequestURI+" "+r.Proto, rec.status, rec.bytes)
+		})
+	}
+}
+
+// responseRecorder records the status and size of a response.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the original ResponseWriter.
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// webMiddlewares returns the middlewares wrapping all routes of the web
+// server, as configured by the flags.
+func webMiddlewares(logger *log.Logger) ([]middleware, error) {
+	middlewares := []middleware{withRequestID}
+	switch *accessLogFormat {
+	case "common", "json":
+		middlewares = append(middlewares, withAccessLog(logger, *accessLogFormat))
+	case "off":
+	default:
+		return nil, fmt.Errorf("invalid -access-log %q: must be common, json or off", *accessLogFormat)
+	}
+	return append(middlewares, withRecover(logger)), nil
+}
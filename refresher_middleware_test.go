@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestMiddlewares(t *testing.T) {
+	var logs bytes.Buffer
+	logger := log.New(&logs, "", 0)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("id " + requestID(r.Context())))
+	})
+	mux.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+		var nums []int
+		nums[4] = 10
+	})
+	h := chain(mux, withRequestID, withAccessLog(logger, "common"), withRecover(logger))
+
+	// A request ID set by the client is kept.
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/ok?a=1", nil)
+	r.Header.Set(requestIDHeader, "abc")
+	h.ServeHTTP(w, r)
+	if w.Body.String() != "id abc" || w.Header().Get(requestIDHeader) != "abc" {
+		t.Errorf("request ID not propagated: %q %q", w.Body, w.Header())
+	}
+	common := regexp.MustCompile(`^192\.0\.2\.1 - - \[\d\d/\w{3}/\d{4}:\d\d:\d\d:\d\d [-+]\d{4}\] "GET /ok\?a=1 HTTP/1\.1" 200 6\n$`)
+	if !common.MatchString(logs.String()) {
+		t.Errorf("unexpected access log %q", logs.String())
+	}
+
+	// A panic is logged and returns a 500 with a new request ID.
+	logs.Reset()
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/panic", nil))
+	if w.Code != http.StatusInternalServerError || len(w.Header().Get(requestIDHeader)) != 16 {
+		t.Errorf("got status %d and header %q", w.Code, w.Header())
+	}
+	if !strings.Contains(logs.String(), "index out of range") || !strings.Contains(logs.String(), `"GET /panic HTTP/1.1" 500`) {
+		t.Errorf("unexpected logs:\n%s", logs.String())
+	}
+}
+
+func TestAccessLogJSON(t *testing.T) {
+	var logs bytes.Buffer
+	h := chain(http.NotFoundHandler(), withRequestID, withAccessLog(log.New(&logs, "", 0), "json"))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/missing", nil))
+
+	var entry accessLogEntry
+	if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Status != http.StatusNotFound || entry.URI != "/missing" || entry.RequestID != w.Header().Get(requestIDHeader) {
+		t.Errorf("unexpected entry %+v", entry)
+	}
+}
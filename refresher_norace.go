@@ -0,0 +1,6 @@
+//go:build !race
+
+package main
+
+// raceEnabled reports whether the race detector is enabled (go build -race).
+const raceEnabled = false
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var outputFormat = flag.String("format", "text", "output format: text or json (one event per line)")
+
+// event is a single print of a section, as written by -format=json.
+type event struct {
+	Time    time.Time `json:"time"`
+	Section string    `json:"section"`
+	Label   string    `json:"label"`
+	Values  []value   `json:"values"`
+	Text    string    `json:"text"`
+	Source  string    `json:"source,omitempty"` // with -show-source
+}
+
+// value is a printed value along with its Go type.
+type value struct {
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+// Print, Printf and Println are used by sections instead of the fmt functions
+// of the same name. In the text format they print exactly what fmt would.
+//
+// In the json format every call is written as an event. Following the
+// "Prefix:label values" convention of the sections, a leading string argument
+// (or the text of a format before its first verb) becomes the event label and
+// the remaining arguments become its values.
+
+func (env *Env) Print(a ...interface{}) {
+	label, values := splitLabel(a)
+	env.emit(label, values, fmt.Sprint(a...))
+}
+
+func (env *Env) Printf(format string, a ...interface{}) {
+	label := format
+	if i := strings.IndexByte(format, '%'); i >= 0 {
+		label = format[:i]
+	}
+	env.emit(strings.TrimSpace(label), a, fmt.Sprintf(format, a...))
+}
+
+func (env *Env) Println(a ...interface{}) {
+	label, values := splitLabel(a)
+	env.emit(label, values, fmt.Sprintln(a...))
+}
+
+// Write makes Env an io.Writer for the sections which hand their output to
+// other packages, such as a log.Logger. In the json format each write is an
+// event without a label.
+func (env *Env) Write(p []byte) (int, error) {
+	env.emit("", nil, string(p))
+	return len(p), nil
+}
+
+// Fail prints err and marks the running section as failed, but lets it go on,
+// like testing.T.Error. The sections use it for errors which would otherwise
+// end the whole program, such as a missing file.
+func (env *Env) Fail(err error) {
+	env.Println("Error:", err)
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	env.errs = append(env.errs, err)
+}
+
+// beginSection prepares env for running the section s.
+func (env *Env) beginSection(s section) {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	env.section = s.name
+	env.errs = nil
+	if env.showSource {
+		env.source = newSourceView(s.fn)
+	}
+}
+
+// endSection writes any output of the running section which is still pending
+// and returns the errors it failed with, joined.
+func (env *Env) endSection() error {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	if env.source != nil {
+		env.writeLines(env.source.flush())
+		env.source = nil
+	}
+	return errors.Join(env.errs...)
+}
+
+// writeLines writes lines of output annotated with their source, or hands them
+// to env.onLine if it is set.
+func (env *Env) writeLines(lines []sourceLine) {
+	for _, l := range lines {
+		if env.onLine != nil {
+			env.onLine(l)
+			continue
+		}
+		env.out.Write([]byte(l.source + l.text))
+	}
+}
+
+// splitLabel separates a leading string label from the values printed after it.
+func splitLabel(a []interface{}) (string, []interface{}) {
+	if len(a) > 0 {
+		if label, ok := a[0].(string); ok {
+			return label, a[1:]
+		}
+	}
+	return "", a
+}
+
+// emit writes a single print of the current section in the selected format.
+func (env *Env) emit(label string, values []interface{}, text string) {
+	env.progress.Add(1)
+	env.mu.Lock()
+	defer env.mu.Unlock()
+
+	var source string
+	if env.source != nil {
+		n := env.source.caller()
+		if !env.json {
+			env.writeLines(env.source.annotate(text, n))
+			return
+		}
+		source = env.source.next(n)
+	}
+
+	if !env.json {
+		env.out.Write([]byte(text))
+		return
+	}
+	ev := event{
+		Time:    env.clock.Now(),
+		Section: env.section,
+		Label:   label,
+		Values:  make([]value, 0, len(values)),
+		Text:    text,
+		Source:  source,
+	}
+	for _, v := range values {
+		ev.Values = append(ev.Values, value{fmt.Sprintf("%T", v), jsonValue(v)})
+	}
+	json.NewEncoder(env.out).Encode(ev)
+}
+
+// jsonValue returns the message of an error, v if it can be encoded as JSON
+// and its fmt representation otherwise (e.g. for functions, channels and
+// complex numbers).
+func jsonValue(v interface{}) interface{} {
+	if err, ok := v.(error); ok {
+		return err.Error() // the fields of most errors are unexported
+	}
+	if _, err := json.Marshal(v); err != nil {
+		return fmt.Sprint(v)
+	}
+	return v
+}
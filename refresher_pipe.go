@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/fakhir/learngo/subprocess"
+)
+
+// The pipe command runs a pipeline of commands given as a single argument,
+// quoted like in a shell, with subprocess.RunPipeline:
+//
+//	$ refresher pipe 'ls -l | grep go | wc -l'
+//
+// The cat and wc commands of the refresher run in-process, as Go function
+// stages between the programs.
+
+// exitStatus is returned by a command which already reported its failure, if
+// it had to, so that main exits with this status.
+type exitStatus int
+
+func (s exitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", int(s))
+}
+
+// pipeBuiltins are the commands which the pipe command runs as Go functions.
+var pipeBuiltins = map[string]func(in commandInputs, args []string, stdout, stderr io.Writer) error{
+	"cat": runCat,
+	"wc":  runWC,
+}
+
+// parsePipeline splits a pipeline into the arguments of its commands. It quotes
+// like a shell: within single quotes every character is literal, within double
+// quotes a backslash only escapes $, `, " and \, and elsewhere a backslash
+// escapes any character. It does no expansion and no redirection.
+func parsePipeline(line string) ([][]string, error) {
+	var cmds [][]string
+	var args []string
+	var word strings.Builder
+	inWord := false // a word is started, even if it is empty like ''
+	endWord := func() {
+		if inWord {
+			args = append(args, word.String())
+			word.Reset()
+			inWord = false
+		}
+	}
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; c {
+		case '\'':
+			j := strings.IndexByte(line[i+1:], '\'')
+			if j < 0 {
+				return nil, errors.New("unterminated single quote")
+			}
+			word.WriteString(line[i+1 : i+1+j])
+			i += j + 1
+			inWord = true
+		case '"':
+			for i++; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' && i+1 < len(line) && strings.IndexByte("$`\"\\", line[i+1]) >= 0 {
+					i++
+				}
+				word.WriteByte(line[i])
+			}
+			if i == len(line) {
+				return nil, errors.New("unterminated double quote")
+			}
+			inWord = true
+		case '\\':
+			if i+1 == len(line) {
+				return nil, errors.New("backslash at the end")
+			}
+			i++
+			word.WriteByte(line[i])
+			inWord = true
+		case ' ', '\t', '\n':
+			endWord()
+		case '|':
+			endWord()
+			if len(args) == 0 {
+				return nil, errors.New("missing command before |")
+			}
+			cmds = append(cmds, args)
+			args = nil
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	endWord()
+	if len(args) == 0 {
+		if len(cmds) == 0 {
+			return nil, errors.New("empty pipeline")
+		}
+		return nil, errors.New("missing command after |")
+	}
+	return append(cmds, args), nil
+}
+
+// pipeCommand implements "refresher pipe".
+func pipeCommand(env *Env, args []string) error {
+	// The flags set reports its errors, after which the pipe command exits
+	// with status 2 like flag.ExitOnError would, but from main.
+	flags := flag.NewFlagSet("pipe", flag.ContinueOnError)
+	builtin := flags.Bool("builtin", true, "run cat and wc in-process instead of the programs of the same name")
+	timeout := flags.Duration("timeout", 0, "kill the pipeline after this duration, unless 0")
+	if err := flags.Parse(args); err == flag.ErrHelp {
+		return nil
+	} else if err != nil {
+		return exitStatus(2)
+	}
+	if flags.NArg() != 1 {
+		return errors.New("pipe takes a single quoted pipeline, such as 'ls -l | grep go | wc -l'")
+	}
+	cmds, err := parsePipeline(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("pipe: %v", err)
+	}
+	in, err := newCommandInputs()
+	if err != nil {
+		return err
+	}
+	defer closeFS(in.fsys)
+
+	ctx, stop := notifySignals(context.Background())
+	defer stop()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	return runPipe(ctx, in, cmds, *builtin, os.Stdout, os.Stderr)
+}
+
+// signalError is the cause of a context cancelled by a signal.
+type signalError struct {
+	sig os.Signal
+}
+
+func (e signalError) Error() string {
+	return "received " + e.sig.String()
+}
+
+// notifySignals returns a copy of ctx which is cancelled when the process gets
+// SIGINT or SIGTERM, with a signalError as its cause, until stop is called.
+// The commands of the subprocess package run in their own process group, out
+// of the reach of the Ctrl-C of the terminal: cancelling their context is what
+// kills them.
+func notifySignals(ctx context.Context) (_ context.Context, stop func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-sigs:
+			cancel(signalError{sig})
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(sigs)
+		cancel(nil)
+	}
+}
+
+// cancelledWriter is the stderr of a builtin stage. Once the pipeline is
+// cancelled, because another stage failed, it timed out or got a signal, the
+// builtin can only fail to read its input, like a killed program which would
+// not report it: what it then writes is dropped, so that only the stage which
+// failed reports why.
+type cancelledWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (w cancelledWriter) Write(p []byte) (int, error) {
+	if w.ctx.Err() != nil {
+		return len(p), nil
+	}
+	return w.w.Write(p)
+}
+
+// runPipe runs the pipeline cmds, reading in.stdin. It returns the exit status
+// of the stage which failed first, after reporting the failures which the
+// stage itself did not report.
+func runPipe(ctx context.Context, in commandInputs, cmds [][]string, builtin bool, stdout, stderr io.Writer) error {
+	stages := make([]subprocess.Stage, len(cmds))
+	for i, args := range cmds {
+		if run, ok := pipeBuiltins[args[0]]; ok && builtin {
+			stages[i] = subprocess.Func(args[0], func(ctx context.Context, stdin io.Reader, stdout io.Writer) error {
+				in := in
+				in.stdin = stdin
+				return run(in, args[1:], stdout, cancelledWriter{ctx, stderr})
+			})
+			continue
+		}
+		stages[i] = subprocess.Command(args[0], args[1:]...)
+		stages[i].Cmd.Stderr = stderr
+	}
+	stages[0].Cmd.Stdin = in.stdin
+	stages[len(stages)-1].Cmd.Stdout = stdout
+
+	_, err := subprocess.RunPipeline(ctx, stages...)
+	var exitErr *subprocess.ExitError
+	var sigErr signalError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(context.Cause(ctx), &sigErr):
+		// Like a shell, only the exit status tells that it was interrupted.
+		if sig, ok := sigErr.sig.(syscall.Signal); ok {
+			return exitStatus(128 + int(sig))
+		}
+		return exitStatus(1)
+	case errors.Is(err, context.DeadlineExceeded):
+		// The error of the stage which was stopped first only tells how.
+		fmt.Fprintln(stderr, "pipe: timed out")
+		return exitStatus(124) // like the timeout command
+	case errors.Is(err, errReported):
+		return exitStatus(1)
+	case errors.As(err, &exitErr):
+		// The command printed why it failed, if it did.
+		if sig, ok := exitErr.Result.Signal.(syscall.Signal); ok {
+			return exitStatus(128 + int(sig))
+		}
+		return exitStatus(exitErr.Result.ExitCode)
+	case errors.Is(err, exec.ErrNotFound):
+		fmt.Fprintln(stderr, "pipe:", err)
+		return exitStatus(127) // like a shell
+	}
+	fmt.Fprintln(stderr, "pipe:", err)
+	return exitStatus(1)
+}
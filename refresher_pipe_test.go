@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRunPipeTimeout checks that a builtin stage waiting for its input stops
+// when the pipeline times out, and that a builtin which fails on its options
+// stops the other stages.
+func TestRunPipeTimeout(t *testing.T) {
+	stdin, w, err := os.Pipe() // nothing is ever written
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	defer w.Close()
+	in := commandInputs{fsys: sampleFS, spec: "sample", stdin: stdin}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var stderr bytes.Buffer
+	start := time.Now()
+	err = runPipe(ctx, in, [][]string{{"cat"}}, true, io.Discard, &stderr)
+	// Only pipe reports why cat stopped.
+	if err != exitStatus(124) || stderr.String() != "pipe: timed out\n" {
+		t.Errorf("got %v, stderr %q, want exit status 124", err, stderr.String())
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("the pipeline ran for %v", elapsed)
+	}
+
+	if _, err := exec.LookPath("sleep"); err != nil {
+		t.Skip("no sleep to run")
+	}
+	start = time.Now()
+	err = runPipe(context.Background(), in, [][]string{{"sleep", "10"}, {"wc", "-x"}}, true, io.Discard, io.Discard)
+	if err != exitStatus(1) {
+		t.Errorf("got %v, want exit status 1", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("the pipeline ran for %v", elapsed)
+	}
+}
+
+func TestParsePipeline(t *testing.T) {
+	for _, c := range []struct {
+		line string
+		want [][]string
+		err  string
+	}{
+		{line: "ls -l | grep go | wc -l", want: [][]string{{"ls", "-l"}, {"grep", "go"}, {"wc", "-l"}}},
+		{line: "  ls|wc\t-l ", want: [][]string{{"ls"}, {"wc", "-l"}}},
+		{line: `grep 'a | b' "c\"d\e" ''`, want: [][]string{{"grep", "a | b", `c"d\e`, ""}}},
+		{line: `echo a\ b\|c x'y'"z"`, want: [][]string{{"echo", "a b|c", "xyz"}}},
+		{line: "", err: "empty pipeline"},
+		{line: "| wc", err: "missing command before |"},
+		{line: "ls || wc", err: "missing command before |"},
+		{line: "ls | ", err: "missing command after |"},
+		{line: "echo 'a", err: "unterminated single quote"},
+		{line: `echo "a\"`, err: "unterminated double quote"},
+		{line: `echo \`, err: "backslash at the end"},
+	} {
+		got, err := parsePipeline(c.line)
+		if c.err != "" {
+			if err == nil || err.Error() != c.err {
+				t.Errorf("parsePipeline(%q): got %q, %v, want error %q", c.line, got, err, c.err)
+			}
+		} else if err != nil || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("parsePipeline(%q): got %q, %v, want %q", c.line, got, err, c.want)
+		}
+	}
+}
+
+// TestRunPipe runs pipelines of programs and of the cat and wc commands, and
+// checks their output and exit status.
+func TestRunPipe(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh to run")
+	}
+	for _, c := range []struct {
+		line           string
+		builtin        bool
+		stdout, stderr string
+		err            error
+	}{
+		{line: "cat /etc/resolv.conf | grep nameserver | wc -l", builtin: true, stdout: "1\n"},
+		{line: "cat -n | tr a-z A-Z", builtin: true, stdout: "1\tFROM STDIN\n"},
+		{line: "sh -c 'echo failed >&2; exit 3' | cat", builtin: true, stderr: "failed\n", err: exitStatus(3)},
+		// cat is still reading when the last stage fails, and must not
+		// report that it was cancelled.
+		{line: "sleep 10 | cat | sh -c 'echo failed >&2; exit 3'", builtin: true, stderr: "failed\n", err: exitStatus(3)},
+		{line: "cat nosuch | sh -c 'cat >/dev/null'", builtin: true, stderr: "cat: nosuch: No such file or directory\n", err: exitStatus(1)},
+		{line: "no-such-command | cat", builtin: true, err: exitStatus(127)},
+		{line: "sh -c 'kill -KILL $$' | cat", err: exitStatus(137)},
+		{line: "yes | head -n 1", stdout: "y\n"},
+	} {
+		cmds, err := parsePipeline(c.line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		in := commandInputs{fsys: sampleFS, spec: "sample", stdin: strings.NewReader("from stdin\n")}
+		var stdout, stderr bytes.Buffer
+		err = runPipe(context.Background(), in, cmds, c.builtin, &stdout, &stderr)
+		if err != c.err {
+			t.Errorf("%s: got error %v, want %v", c.line, err, c.err)
+		}
+		// The numbers of cat and wc are aligned with spaces.
+		if got := strings.TrimLeft(stdout.String(), " "); got != c.stdout {
+			t.Errorf("%s: got stdout %q, want %q", c.line, stdout.String(), c.stdout)
+		}
+		if c.stderr != "" && stderr.String() != c.stderr {
+			t.Errorf("%s: got stderr %q, want %q", c.line, stderr.String(), c.stderr)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestRunPipeInterrupt interrupts a pipeline like Ctrl-C would, and checks
+// that its commands, which are not in the process group of the terminal, are
+// killed.
+func TestRunPipeInterrupt(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh to run")
+	}
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	cmds, err := parsePipeline("sh -c 'echo $$ >" + pidFile + "; exec sleep 30' | cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, stop := notifySignals(context.Background())
+	defer stop()
+	in := commandInputs{fsys: sampleFS, spec: "sample", stdin: strings.NewReader("")}
+	done := make(chan error, 1)
+	go func() {
+		done <- runPipe(ctx, in, cmds, true, io.Discard, io.Discard)
+	}()
+
+	var pid int
+	for deadline := time.Now().Add(5 * time.Second); pid == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("sleep did not start")
+		}
+		data, _ := os.ReadFile(pidFile)
+		pid, _ = strconv.Atoi(strings.TrimSpace(string(data)))
+	}
+	if err := syscall.Kill(os.Getpid(), syscall.SIGINT); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != exitStatus(130) {
+			t.Errorf("got %v, want exit status 130", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the pipeline still runs")
+	}
+	if err := syscall.Kill(pid, 0); err != syscall.ESRCH {
+		t.Errorf("sleep still runs: %v", err)
+	}
+}
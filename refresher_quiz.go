@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"go/scanner"
+	"go/token"
+	"io"
+	"math/rand"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// question asks for the output of a few statements of a section.
+type question struct {
+	section string
+	context string   // the statements of the section before them
+	source  string   // the statements, as shown by -show-source
+	want    []string // the lines they print
+}
+
+// unpredictable matches the output which differs on every run even with
+// -deterministic, or which cannot be guessed from the statements: pointers,
+// and the dates printed by the log package.
+var unpredictable = regexp.MustCompile(`0x[0-9a-f]+|\d{4}/\d{2}/\d{2}`)
+
+// quizCommand implements "refresher quiz".
+func quizCommand(env *Env, args []string) error {
+	fs := flag.NewFlagSet("quiz", flag.ExitOnError)
+	n := fs.Int("n", 5, "number of questions")
+	fs.Parse(args)
+
+	selected, err := matchSections(*runSections)
+	if err != nil {
+		return err
+	}
+	var candidates []section
+	for _, s := range selected {
+		if !s.external && !s.manual && !s.leaks {
+			candidates = append(candidates, s)
+		}
+	}
+	return quiz(os.Stdin, os.Stdout, env.rand, candidates, *n)
+}
+
+// quizQuestions runs s in a deterministic environment and returns a question
+// for every group of statements which prints something, except for the ones
+// whose output is unpredictable. The statements are shown without their
+// comments, which often tell what they print.
+func quizQuestions(s section) []question {
+	var questions []question
+	var context strings.Builder
+	env := newDeterministicEnv(deterministicSeed, deterministicNow)
+	env.showSource = true
+	env.onLine = func(l sourceLine) {
+		if l.source != "" {
+			source := stripComments(l.source)
+			questions = append(questions, question{section: s.name, context: context.String(), source: source})
+			context.WriteString(source)
+		}
+		if len(questions) > 0 {
+			q := &questions[len(questions)-1]
+			q.want = append(q.want, strings.TrimSuffix(l.text, "\n"))
+		}
+	}
+	s.run(env)
+
+	valid := questions[:0]
+	for _, q := range questions {
+		if !unpredictable.MatchString(strings.Join(q.want, "\n")) {
+			valid = append(valid, q)
+		}
+	}
+	return valid
+}
+
+// stripComments removes the comments from source, as shown by -show-source,
+// along with the lines left empty.
+func stripComments(source string) string {
+	lines := strings.Split(strings.TrimSuffix(source, "\n"), "\n")
+	prefixes := make([]string, len(lines)) // the line numbers
+	for i, l := range lines {
+		if j := strings.Index(l, " | "); j >= 0 {
+			prefixes[i], lines[i] = l[:j+3], l[j+3:]
+		}
+	}
+	code := []byte(strings.Join(lines, "\n"))
+
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(code))
+	var sc scanner.Scanner
+	sc.Init(file, code, nil, scanner.ScanComments)
+	for {
+		pos, tok, lit := sc.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if tok == token.COMMENT {
+			offset := file.Offset(pos)
+			for i := offset; i < offset+len(lit); i++ {
+				if code[i] != '\n' {
+					code[i] = ' '
+				}
+			}
+		}
+	}
+
+	var b strings.Builder
+	for i, l := range strings.Split(string(code), "\n") {
+		if l = strings.TrimRight(l, " \t"); l != "" {
+			b.WriteString(prefixes[i] + l + "\n")
+		}
+	}
+	return b.String()
+}
+
+// quiz asks up to n questions picked at random from the candidate sections,
+// reading the answers from in, and ends with the score and the sections which
+// are worth reviewing.
+func quiz(in io.Reader, out io.Writer, rnd *rand.Rand, candidates []section, n int) error {
+	fmt.Fprintln(out, "Predict what the statements print. The sections run with -deterministic:")
+	fmt.Fprintf(out, "on linux with 4 CPUs at %v.\n", deterministicNow.Format("15:04 MST on Jan 2, 2006"))
+
+	answers := bufio.NewScanner(in)
+	remaining := make(map[string][]question)
+	var asked, correct int
+	var missed []string
+
+quiz:
+	for asked < n && len(candidates) > 0 {
+		i := rnd.Intn(len(candidates))
+		s := candidates[i]
+		questions, ok := remaining[s.name]
+		if !ok {
+			questions = quizQuestions(s)
+		}
+		if len(questions) == 0 {
+			candidates = append(candidates[:i], candidates[i+1:]...)
+			continue
+		}
+		j := rnd.Intn(len(questions))
+		q := questions[j]
+		remaining[s.name] = append(questions[:j], questions[j+1:]...)
+
+		asked++
+		fmt.Fprintf(out, "\nQuestion %d (%s):\n", asked, q.section)
+		if q.context != "" {
+			fmt.Fprintf(out, "The section runs:\n%sand then:\n", q.context)
+		}
+		fmt.Fprint(out, q.source)
+		if len(q.want) == 1 {
+			fmt.Fprintln(out, "What do these statements print? (1 line)")
+		} else {
+			fmt.Fprintf(out, "What do these statements print? (%d lines)\n", len(q.want))
+		}
+
+		right := true
+		for _, want := range q.want {
+			fmt.Fprint(out, "> ")
+			if !answers.Scan() {
+				asked--
+				break quiz
+			}
+			if strings.Join(strings.Fields(answers.Text()), " ") != strings.Join(strings.Fields(want), " ") {
+				right = false
+			}
+		}
+		if right {
+			correct++
+			fmt.Fprintln(out, "Correct!")
+			continue
+		}
+		fmt.Fprintf(out, "Not quite, it prints:\n%s\n", strings.Join(q.want, "\n"))
+		missed = appendUnique(missed, q.section)
+	}
+	if err := answers.Err(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "\nScore: %d/%d\n", correct, asked)
+	if len(missed) > 0 {
+		fmt.Fprintln(out, "Topics to review:", strings.Join(missed, ", "))
+	}
+	return nil
+}
+
+// appendUnique appends s to list unless it is already there.
+func appendUnique(list []string, s string) []string {
+	for _, l := range list {
+		if l == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// TestQuizQuestions checks that the statements of a section are split into
+// questions along with the lines they print.
+func TestQuizQuestions(t *testing.T) {
+	questions := quizQuestions(section{name: "deferredFunctions", fn: deferredFunctions})
+	if len(questions) != 3 {
+		t.Fatalf("got %d questions, want 3: %+v", len(questions), questions)
+	}
+	q := questions[2]
+	if !strings.Contains(q.source, "defer func() { ret = 20 }()") {
+		t.Errorf("question does not show the deferred function:\n%s", q.source)
+	}
+	want := []string{"Defer:return value modified 20", "Defer:Count 4", "Defer:Count 3", "Defer:Count 2", "Defer:Count 1", "Defer:Count 0"}
+	if strings.Join(q.want, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got answer %q, want %q", q.want, want)
+	}
+}
+
+// TestQuiz answers one question right and one wrong.
+func TestQuiz(t *testing.T) {
+	candidates := []section{{name: "moreOnSlices", fn: moreOnSlices}}
+	questions := quizQuestions(candidates[0])
+
+	// Pick the same questions as the quiz will.
+	rnd := rand.New(rand.NewSource(1))
+	rnd.Intn(len(candidates))
+	first := questions[rnd.Intn(len(questions))]
+
+	answers := strings.Join(first.want, "\n") + "\n  wrong   answer \n"
+	var out strings.Builder
+	if err := quiz(strings.NewReader(answers), &out, rand.New(rand.NewSource(1)), candidates, 2); err != nil {
+		t.Fatal(err)
+	}
+	got := out.String()
+	if !strings.Contains(got, "Correct!") || !strings.Contains(got, "Not quite") {
+		t.Errorf("unexpected quiz:\n%s", got)
+	}
+	if !strings.HasSuffix(got, "Score: 1/2\nTopics to review: moreOnSlices\n") {
+		t.Errorf("unexpected summary:\n%s", got)
+	}
+}
+
+// TestQuizContext checks that a question shows the statements before it, and
+// no comments since they may give the answer away.
+func TestQuizContext(t *testing.T) {
+	for _, q := range quizQuestions(section{name: "moreOnSlices", fn: moreOnSlices}) {
+		if strings.Contains(q.context+q.source, "//") {
+			t.Errorf("question shows comments:\n%s", q.context+q.source)
+		}
+		if strings.Contains(q.source, "s2 := s1[2:6]") && !strings.Contains(q.context, "s1 := []int{") {
+			t.Errorf("question does not show how s1 is made:\n%s", q.context+q.source)
+		}
+	}
+}
+
+// TestQuizUnpredictable checks that no question asks for a date or a pointer.
+func TestQuizUnpredictable(t *testing.T) {
+	questions := quizQuestions(section{name: "methodsAndInterfaces", fn: methodsAndInterfaces})
+	if len(questions) == 0 {
+		t.Fatal("no questions")
+	}
+	for _, q := range questions {
+		if unpredictable.MatchString(strings.Join(q.want, "\n")) {
+			t.Errorf("question asks for %q", q.want)
+		}
+	}
+}
+
+func TestStripComments(t *testing.T) {
+	source := "  10 | // Make x.\n  11 | x := \"a // b\" /* c */ + `d`\n  12 | \n  13 | f(x) // prints a // bd\n"
+	want := "  11 | x := \"a // b\"         + `d`\n  13 | f(x)\n"
+	if got := stripComments(source); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
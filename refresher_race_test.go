@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+// TestCounters checks the synchronized counters of the dataRaces section.
+// Run it with the race detector to check that they are free of data races:
+// $ go test -race -run 'TestCounters|TestGolden/dataRaces'
+func TestCounters(t *testing.T) {
+	const goroutines, n = 8, 1000
+	for name, count := range map[string]func(goroutines, n int) int{
+		"mutex":   mutexCount,
+		"atomic":  atomicCount,
+		"channel": channelCount,
+	} {
+		if got := count(goroutines, n); got != goroutines*n {
+			t.Errorf("%s: got %d, want %d", name, got, goroutines*n)
+		}
+	}
+	if !raceEnabled {
+		t.Log("run with -race to check for data races")
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+)
+
+var (
+	listSections = flag.Bool("list", false, "list the sections and exit")
+	runSections  = flag.String("run", "", "run only the sections whose name matches this regular expression")
+	catFile      = flag.String("file", "/etc/hosts", "file printed by the communicationInGo section, in the filesystem selected by -fs")
+)
+
+// section is a single topic of the refresher which can be run on its own.
+type section struct {
+	name string
+	fn   func(env *Env)
+	// external is set for the sections whose output depends on the machine,
+	// the network or the user, even in a deterministic environment.
+	external bool
+	// interactive is set for the sections which wait for the user, so they
+	// can only run from the command line.
+	interactive bool
+	// manual is set for the sections which only run when -run matches them,
+	// such as the deadlock demonstrations.
+	manual bool
+	// global is set for the sections which change the state of the whole
+	// process, such as GOMAXPROCS, and thus disturb anything running
+	// alongside them.
+	global bool
+	// concurrent is set for the sections which start goroutines, which the
+	// tests check are all gone when the section returns.
+	concurrent bool
+	// leaks is set for the sections which leave a goroutine running on
+	// purpose, so that a long-running process such as the web server must not
+	// run them.
+	leaks bool
+}
+
+// sections is the registry of every section in the order they are run. It is
+// the single source of truth for main, the tests and any other tooling that
+// needs to enumerate the sections, so new topics must be added here. A name
+// like "moreOnChannels/finiteWriter" is a sub-section of moreOnChannels, which
+// -run moreOnChannels selects along with it.
+//
+// It is set by init since the web server, which is one of the sections, also
+// lists the sections.
+var sections []section
+
+func init() {
+	sections = []section{
+		{name: "variableDeclarations", fn: variableDeclarations},
+		{name: "functionsInGo", fn: functionsInGo},
+		{name: "controlFlow", fn: controlFlow},
+		{name: "deferredFunctions", fn: deferredFunctions},
+		{name: "panicAndRecover", fn: panicAndRecover},
+		{name: "pointersInGo", fn: pointersInGo},
+		{name: "structDataType", fn: structDataType},
+		{name: "arrayDataType", fn: arrayDataType},
+		{name: "moreOnSlices", fn: moreOnSlices},
+		{name: "mapDataType", fn: mapDataType},
+		{name: "makeAndNew", fn: makeAndNew},
+		{name: "constructorsInGo", fn: constructorsInGo},
+		{name: "concurrencyAndChannels", fn: concurrencyAndChannels, concurrent: true},
+		{name: "moreOnChannels", fn: moreOnChannels, concurrent: true},
+		{name: "moreOnChannels/infiniteWriter", fn: infiniteWriter, concurrent: true, leaks: true}, // the writer blocks forever
+		{name: "moreOnChannels/infiniteWriterWithQuit", fn: infiniteWriterWithQuit, concurrent: true},
+		{name: "moreOnChannels/finiteWriter", fn: finiteWriter, concurrent: true},
+		{name: "moreOnChannels/asyncFunction", fn: asyncFunction, concurrent: true},
+		{name: "moreOnChannels/channelSemaphore", fn: channelSemaphore, concurrent: true},
+		{name: "moreOnChannels/weightedSemaphore", fn: weightedSemaphore, concurrent: true},
+		{name: "moreOnChannels/selectTimeout", fn: selectTimeout, concurrent: true},
+		{name: "dataRaces", fn: dataRaces, external: true, concurrent: true},                             // lost updates depend on the scheduler
+		{name: "concurrencyVsParallelism", fn: concurrencyVsParallelism, global: true, concurrent: true}, // runs the bench, which sets GOMAXPROCS
+		{name: "deadlockReadTooMany", fn: deadlockReadTooMany, manual: true},
+		{name: "deadlockLockTwice", fn: deadlockLockTwice, manual: true},
+		{name: "deadlockWaitForEachOther", fn: deadlockWaitForEachOther, manual: true},
+		{name: "typeSwitchAndTypeAssertion", fn: typeSwitchAndTypeAssertion},
+		{name: "methodsAndInterfaces", fn: methodsAndInterfaces},
+		{name: "errorHandling", fn: errorHandling},
+		{name: "communicationInGo", fn: communicationInGo, external: true},
+		{name: "wrappedErrors", fn: wrappedErrors, external: true},                 // runs sh
+		{name: "execCommands", fn: execCommands, external: true, concurrent: true}, // runs sh
+		{name: "setupWebserv", fn: setupWebserv, external: true, interactive: true},
+	}
+}
+
+// run runs the section in env and returns the errors it failed with, joined,
+// or nil if it did not fail.
+func (s section) run(env *Env) (err error) {
+	env.beginSection(s)
+	defer func() { err = env.endSection() }()
+	s.fn(env)
+	return nil
+}
+
+// matchSections returns the registered sections whose name matches pattern,
+// in registration order. Like "go test -run", an empty pattern matches all,
+// except for the manual sections.
+func matchSections(pattern string) ([]section, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -run pattern: %v", err)
+	}
+	var matched []section
+	for _, s := range sections {
+		if s.manual && pattern == "" {
+			continue
+		}
+		if re.MatchString(s.name) {
+			matched = append(matched, s)
+		}
+	}
+	return matched, nil
+}
+
+// printSections writes the name of every registered section, one per line.
+func printSections(w io.Writer) {
+	for _, s := range sections {
+		fmt.Fprintln(w, s.name)
+	}
+}
+
+// command is a subcommand of the refresher, run with the arguments which
+// follow its name on the command line instead of running the sections.
+type command struct {
+	name  string
+	usage string
+	run   func(env *Env, args []string) error
+}
+
+var commands = []command{
+	{"quiz", "quiz [-n questions]: predict the output of the sections matching -run", quizCommand},
+	{"bench", "bench [-procs list] [-workers list] [-csv file]: measure the speedup of CPU-bound workloads", benchCommand},
+	{"cat", "cat [-nvETA] [file ...]: print files like GNU cat, - being stdin", catCommand},
+	{"wc", "wc [-lwmc] [file ...]: count the lines, words, characters and bytes of files like GNU wc", wcCommand},
+	{"pipe", "pipe [-builtin=false] [-timeout d] 'cmd | cmd ...': run a shell-style pipeline, with cat and wc in-process", pipeCommand},
+}
+
+// runSubcommand runs the command named by args[0].
+func runSubcommand(env *Env, args []string) error {
+	for _, c := range commands {
+		if c.name == args[0] {
+			return c.run(env, args[1:])
+		}
+	}
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+// usage prints the help of the command line for flag.Usage.
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "usage: %s [flags] [command [arguments]]\n\nCommands:\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(out, "  %s\n", c.usage)
+	}
+	fmt.Fprintf(out, "\nFlags:\n")
+	flag.PrintDefaults()
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	_ "embed"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+var showSource = flag.Bool("show-source", false, "print the statements of a section next to the output they produce")
+
+// refresherSource is the source of the notes, embedded so that the binary
+// can show it without access to the repository.
+//
+//go:embed refresher.go
+var refresherSource string
+
+const refresherFile = "refresher.go"
+
+// sourceFile is the parsed refresherSource.
+type sourceFile struct {
+	fset  *token.FileSet
+	file  *ast.File
+	lines []string
+}
+
+var (
+	parseOnce sync.Once
+	parsed    *sourceFile
+	parseErr  error
+)
+
+// loadSource parses refresherSource the first time it is called.
+func loadSource() (*sourceFile, error) {
+	parseOnce.Do(func() {
+		fset := token.NewFileSet()
+		file, err := parser.ParseFile(fset, refresherFile, refresherSource, parser.ParseComments)
+		if err != nil {
+			parseErr = err
+			return
+		}
+		parsed = &sourceFile{fset, file, strings.Split(refresherSource, "\n")}
+	})
+	return parsed, parseErr
+}
+
+// funcName returns the name of fn as reported in stack traces, e.g.
+// "main.controlFlow".
+func funcName(fn interface{}) string {
+	return runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name()
+}
+
+// funcSource returns the source of the function fn, with its doc comment, or
+// "" if it is not in refresherSource.
+func funcSource(fn interface{}) string {
+	src, err := loadSource()
+	if err != nil {
+		return ""
+	}
+	if f := src.findFunc(funcName(fn)); f != nil {
+		first := f.Pos()
+		if f.Doc != nil {
+			first = f.Doc.Pos()
+		}
+		return refresherSource[src.fset.Position(first).Offset:src.fset.Position(f.End()).Offset] + "\n"
+	}
+	return ""
+}
+
+// findFunc returns the declaration of the function with the given name, as
+// returned by funcName.
+func (src *sourceFile) findFunc(name string) *ast.FuncDecl {
+	for _, decl := range src.file.Decls {
+		// The package is "main" in the binary but named after its import
+		// path in tests.
+		if f, ok := decl.(*ast.FuncDecl); ok && f.Recv == nil && strings.HasSuffix(name, "."+f.Name.Name) {
+			return f
+		}
+	}
+	return nil
+}
+
+// sourceView tracks which statements of a running section have been shown.
+// Only the top level statements of the section function are shown, each one
+// together with the comments and blank lines which lead up to it.
+type sourceView struct {
+	src     *sourceFile
+	fn      string // function name as reported by runtime.Frame
+	body    *ast.BlockStmt
+	shown   int             // number of statements shown so far
+	pending int             // number of statements to show with the current line
+	line    strings.Builder // current line of text output, up to its newline
+}
+
+// newSourceView returns a view of the function fn, or nil if its source is not
+// in refresherSource.
+func newSourceView(fn interface{}) *sourceView {
+	src, err := loadSource()
+	if err != nil {
+		return nil
+	}
+	name := funcName(fn)
+	if f := src.findFunc(name); f != nil {
+		return &sourceView{src: src, fn: name, body: f.Body}
+	}
+	return nil
+}
+
+// caller returns the number of statements of the section which must be shown
+// for the code currently printing: all statements up to the one being
+// executed by the section function. Output from a goroutine started by the
+// section is attributed to the statement which defines the goroutine's code.
+func (v *sourceView) caller() int {
+	pcs := make([]uintptr, 64)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(1, pcs)])
+	line := 0
+	for {
+		f, more := frames.Next()
+		if filepath.Base(f.File) == refresherFile {
+			if f.Function == v.fn {
+				line = f.Line
+				break
+			}
+			if line == 0 {
+				line = f.Line
+			}
+		}
+		if !more {
+			break
+		}
+	}
+	for i, stmt := range v.body.List {
+		if v.lineOf(stmt.End()) >= line {
+			if v.lineOf(stmt.Pos()) <= line {
+				return i + 1
+			}
+			break
+		}
+	}
+	return 0
+}
+
+func (v *sourceView) lineOf(pos token.Pos) int {
+	return v.src.fset.Position(pos).Line
+}
+
+// next returns the source of the statements which have not been shown yet,
+// up to statement n, and marks them as shown. Every line is prefixed by its
+// line number in refresherFile.
+func (v *sourceView) next(n int) string {
+	if n <= v.shown {
+		return ""
+	}
+	first := v.lineOf(v.body.Lbrace) + 1
+	if v.shown > 0 {
+		first = v.lineOf(v.body.List[v.shown-1].End()) + 1
+	}
+	last := v.lineOf(v.body.List[n-1].End())
+	v.shown = n
+
+	for first < last && strings.TrimSpace(v.src.lines[first-1]) == "" {
+		first++
+	}
+	var b strings.Builder
+	for i := first; i <= last; i++ {
+		fmt.Fprintf(&b, "%4d | %s\n", i, strings.TrimPrefix(v.src.lines[i-1], "\t"))
+	}
+	return b.String()
+}
+
+// sourceLine is a line of output along with the source of the statements
+// which produced it, if they have not been shown with an earlier line.
+type sourceLine struct {
+	source string
+	text   string
+}
+
+// annotate splits the text printed by the statement n of a section into
+// complete lines, each with the statements which produced it. Text after the
+// last newline is kept until the line is completed or the section ends.
+func (v *sourceView) annotate(text string, n int) []sourceLine {
+	if n > v.pending {
+		v.pending = n
+	}
+	var lines []sourceLine
+	for {
+		i := strings.IndexByte(text, '\n')
+		if i < 0 {
+			v.line.WriteString(text)
+			return lines
+		}
+		lines = append(lines, sourceLine{v.next(v.pending), v.line.String() + text[:i+1]})
+		v.line.Reset()
+		text = text[i+1:]
+	}
+}
+
+// flush returns the partial line not shown yet, if any.
+func (v *sourceView) flush() []sourceLine {
+	if v.line.Len() == 0 {
+		return nil
+	}
+	l := sourceLine{v.next(v.pending), v.line.String()}
+	v.line.Reset()
+	return []sourceLine{l}
+}
@@ -1,7 +1,18 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
 	"testing"
 )
 
@@ -41,3 +52,179 @@ func ExampleSwap() {
 	// Output:
 	// two one
 }
+
+// TestMatchSections checks that -run selects sections by regular expression
+// and keeps the registration order.
+func TestMatchSections(t *testing.T) {
+	all, err := matchSections("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manual := 0
+	for _, s := range sections {
+		if s.manual {
+			manual++
+		}
+	}
+	if len(all) != len(sections)-manual {
+		t.Fatalf("empty pattern matched %d sections, want %d", len(all), len(sections)-manual)
+	}
+
+	matched, err := matchSections("Slices|^map")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matched) != 2 || matched[0].name != "moreOnSlices" || matched[1].name != "mapDataType" {
+		t.Errorf("unexpected sections matched: %v", matched)
+	}
+
+	if _, err := matchSections("("); err == nil {
+		t.Error("invalid pattern did not return an error")
+	}
+}
+
+// TestSectionNames makes sure that every registered section can be selected
+// on its own.
+func TestSectionNames(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, s := range sections {
+		if s.name == "" || s.fn == nil {
+			t.Errorf("incomplete section %+v", s)
+		}
+		if seen[s.name] {
+			t.Errorf("section %q registered twice", s.name)
+		}
+		seen[s.name] = true
+	}
+}
+
+// TestDeterministicEnv checks that two deterministic environments agree on
+// everything a section may print.
+func TestDeterministicEnv(t *testing.T) {
+	a := newDeterministicEnv(deterministicSeed, deterministicNow)
+	b := newDeterministicEnv(deterministicSeed, deterministicNow)
+	for i := 0; i < 10; i++ {
+		if x, y := a.rand.Intn(100), b.rand.Intn(100); x != y {
+			t.Fatalf("random values differ: %d != %d", x, y)
+		}
+	}
+	if !a.clock.Now().Equal(deterministicNow) || a.goos != b.goos || a.maxProcs != b.maxProcs {
+		t.Errorf("environments differ: %+v %+v", a, b)
+	}
+}
+
+// TestSeedZero checks that -seed 0 selects the seed 0, rather than the
+// default seed.
+func TestSeedZero(t *testing.T) {
+	if err := flag.Set("seed", "0"); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { *randSeed = 0 }()
+	env, err := envFromFlags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := rand.New(rand.NewSource(0))
+	for i := 0; i < 10; i++ {
+		if x, y := env.rand.Intn(100), want.Intn(100); x != y {
+			t.Fatalf("random values differ from the seed 0: %d != %d", x, y)
+		}
+	}
+}
+
+// TestJSONFormat checks the events written by -format=json.
+func TestJSONFormat(t *testing.T) {
+	var out strings.Builder
+	env := newDeterministicEnv(deterministicSeed, deterministicNow)
+	env.out = &out
+	env.json = true
+	section{name: "moreOnSlices", fn: moreOnSlices}.run(env)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	var ev event
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &ev); err != nil {
+		t.Fatal(err)
+	}
+	if ev.Section != "moreOnSlices" || ev.Label != "Slices:copy2:" || !ev.Time.Equal(deterministicNow) {
+		t.Errorf("unexpected event %+v", ev)
+	}
+	if ev.Text != "Slices:copy2: 4 [2 3 4 5 4 5]\n" {
+		t.Errorf("unexpected text %q", ev.Text)
+	}
+	if len(ev.Values) != 2 || ev.Values[0].Type != "int" || ev.Values[1].Type != "[]int" {
+		t.Errorf("unexpected values %+v", ev.Values)
+	}
+}
+
+// TestShowSource checks that -show-source prints the statements which
+// produced a line of output right before it.
+func TestShowSource(t *testing.T) {
+	var out strings.Builder
+	env := newDeterministicEnv(deterministicSeed, deterministicNow)
+	env.out = &out
+	env.showSource = true
+	section{name: "moreOnSlices", fn: moreOnSlices}.run(env)
+
+	got := out.String()
+	copy1 := strings.Index(got, "Slices:copy1:")
+	copy2 := strings.Index(got, "copylen = copy(s4, s4[2:])")
+	if copy1 < 0 || copy2 < copy1 || !strings.HasSuffix(got, "\nSlices:copy2: 4 [2 3 4 5 4 5]\n") {
+		t.Errorf("source not shown before its output:\n%s", got)
+	}
+	if strings.Count(got, "s1 := []int{0, 1, 2, 3, 4, 5, 6, 7}") != 1 {
+		t.Errorf("statement not shown exactly once:\n%s", got)
+	}
+}
+
+// TestHelperErrors checks that the helpers of communicationInGo return wrapped
+// errors instead of ending the program.
+func TestHelperErrors(t *testing.T) {
+	env := newDeterministicEnv(deterministicSeed, deterministicNow)
+	env.out = io.Discard
+	fsys := os.DirFS(t.TempDir())
+	if _, err := cat(env, fsys, "missing"); !errors.Is(err, fs.ErrNotExist) || !strings.HasPrefix(err.Error(), "cat: ") {
+		t.Errorf("cat: got %v", err)
+	}
+	if _, err := catBuffered(env, fsys, "missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("catBuffered: got %v", err)
+	}
+
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh to run")
+	}
+	err := runCommand(env, "sh", "-c", "echo out; echo err >&2; exit 2")
+	var cmdErr *CommandFailedError
+	if !errors.As(err, &cmdErr) || cmdErr.ExitCode != 2 || cmdErr.Stderr != "err\n" {
+		t.Errorf("runCommand: got %#v", err)
+	}
+	if err == nil || err.Error() != "sh -c echo out; echo err >&2; exit 2: exit status 2: err" {
+		t.Errorf("runCommand: got message %v", err)
+	}
+	if err := runCommand(env, "sh", "-c", "true"); err != nil {
+		t.Errorf("runCommand: got %v", err)
+	}
+}
+
+// TestWrappedErrorsWithoutSh checks that wrappedErrors does without sh.
+func TestWrappedErrorsWithoutSh(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	var out strings.Builder
+	env := newDeterministicEnv(deterministicSeed, deterministicNow)
+	env.out = &out
+	wrappedErrors(env)
+	if !strings.Contains(out.String(), "Wrapped:joined as *CommandFailedError: false\n") {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+}
+
+// TestHTTPGetStatus checks that httpGet reports an unexpected status with a
+// wrapped sentinel error.
+func TestHTTPGetStatus(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	env := newDeterministicEnv(deterministicSeed, deterministicNow)
+	_, err := httpGet(env, srv.URL)
+	if !errors.Is(err, errHTTPStatus) || !strings.HasSuffix(err.Error(), ": 404 Not Found") {
+		t.Errorf("got %v", err)
+	}
+}
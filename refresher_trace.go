@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/fakhir/learngo/chantrace"
+	"github.com/fakhir/learngo/workerpool"
+)
+
+var (
+	traceFormat = flag.String("trace", "off", "record the goroutines and channel operations of the sections and write them as a sequence diagram: ascii, svg, chrome (trace event JSON for chrome://tracing) or off")
+	traceOut    = flag.String("trace-out", "-", "`file` to write the -trace diagram to, - for standard output")
+)
+
+// newTracer returns the tracer selected by -trace, or nil if tracing is off.
+// Sections record their channel operations with env.trace, which does nothing
+// when it is nil.
+func newTracer() (*chantrace.Tracer, error) {
+	switch *traceFormat {
+	case "off":
+		return nil, nil
+	case "ascii", "svg", "chrome":
+		return chantrace.New("main"), nil
+	default:
+		return nil, fmt.Errorf("invalid -trace %q: must be ascii, svg, chrome or off", *traceFormat)
+	}
+}
+
+// writeTrace writes what tr recorded to -trace-out in the -trace format.
+func writeTrace(tr *chantrace.Tracer) (err error) {
+	var w io.Writer = os.Stdout
+	if *traceOut != "-" {
+		f, err := os.Create(*traceOut)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}()
+		w = f
+	}
+	switch *traceFormat {
+	case "svg":
+		return tr.WriteSVG(w)
+	case "chrome":
+		return tr.WriteChrome(w)
+	default:
+		return tr.WriteASCII(w)
+	}
+}
+
+// poolTrace returns the hooks recording the workers of a workerpool and the
+// operations on its channels with tr, or nil if tr is nil. The goroutines
+// sending on a channel of the pool, or receiving from it, take turns, so that
+// tr pairs every receive with its own send.
+func poolTrace(tr *chantrace.Tracer) *workerpool.Trace {
+	if tr == nil {
+		return nil
+	}
+	type turn struct {
+		ch   any
+		kind chantrace.Kind
+	}
+	var mu sync.Mutex
+	turns := make(map[turn]*sync.Mutex)
+	op := func(kind chantrace.Kind, record func(ch, v any)) func(ch any) func(v any, ok bool) {
+		return func(ch any) func(v any, ok bool) {
+			mu.Lock()
+			t := turns[turn{ch, kind}]
+			if t == nil {
+				t = new(sync.Mutex)
+				turns[turn{ch, kind}] = t
+			}
+			mu.Unlock()
+			t.Lock()
+			return func(v any, ok bool) {
+				if ok {
+					record(ch, v)
+				}
+				t.Unlock()
+			}
+		}
+	}
+	return &workerpool.Trace{
+		Channel:     tr.Name,
+		WorkerStart: func() { tr.Start("worker") },
+		Send:        op(chantrace.Send, tr.Send),
+		Recv:        op(chantrace.Recv, tr.Recv),
+		Close:       tr.Close,
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/fakhir/learngo/chantrace"
+	"github.com/fakhir/learngo/workerpool"
+)
+
+func TestTrace(t *testing.T) {
+	env := newDeterministicEnv(deterministicSeed, deterministicNow)
+	env.out = io.Discard
+	env.trace = chantrace.New("main")
+	concurrencyAndChannels(env)
+
+	var b strings.Builder
+	if err := env.trace.WriteASCII(&b); err != nil {
+		t.Fatal(err)
+	}
+	got := b.String()
+	// Coffee is ready first and sends 6, then Tea sends 3.
+	coffee, tea := strings.Index(got, "numberChan: 6"), strings.Index(got, "numberChan: 3")
+	if !strings.HasPrefix(got, "main ") || coffee == -1 || tea < coffee {
+		t.Errorf("unexpected trace:\n%s", got)
+	}
+	for _, name := range []string{"Coffee", "Tea"} {
+		if !strings.Contains(got, name) {
+			t.Errorf("goroutine %s missing from the trace:\n%s", name, got)
+		}
+	}
+}
+
+// TestTraceMoreOnChannels checks the trace of the Fibonacci generator and of
+// the worker pool, which records its own channel operations.
+func TestTraceMoreOnChannels(t *testing.T) {
+	env := newDeterministicEnv(deterministicSeed, deterministicNow)
+	env.out = io.Discard
+	env.trace = chantrace.New("main")
+	moreOnChannels(env)
+
+	var b strings.Builder
+	if err := env.trace.WriteASCII(&b); err != nil {
+		t.Fatal(err)
+	}
+	got := b.String()
+	for _, s := range []string{"fib: 8", "close x", "submitter", "worker", "queue: ", "results: 45"} {
+		if !strings.Contains(got, s) {
+			t.Errorf("%q missing from the trace:\n%s", s, got)
+		}
+	}
+}
+
+// TestPoolTrace checks that each job received from the queue of a pool is
+// paired with its own send, while several goroutines submit jobs.
+func TestPoolTrace(t *testing.T) {
+	tr := chantrace.New("main")
+	ctx := workerpool.WithTrace(context.Background(), poolTrace(tr))
+	pool := workerpool.New(ctx, 4, 2, func(ctx context.Context, x int) (int, error) {
+		return x * x, nil
+	})
+	var submitters sync.WaitGroup
+	for k := 0; k < 3; k++ {
+		submitters.Add(1)
+		go func() {
+			defer submitters.Done()
+			tr.Start(fmt.Sprint("submitter", k))
+			for i := 0; i < 20; i++ {
+				pool.Submit(ctx, 100*k+i)
+			}
+		}()
+	}
+	go func() {
+		submitters.Wait()
+		pool.Close()
+	}()
+	for range pool.Results() {
+	}
+
+	var b strings.Builder
+	if err := tr.WriteChrome(&b); err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		TraceEvents []struct {
+			Name string
+			Ph   string
+			Ts   float64
+			Tid  int
+			ID   int
+			Args map[string]string
+		}
+	}
+	if err := json.Unmarshal([]byte(b.String()), &got); err != nil {
+		t.Fatal(err)
+	}
+	type at struct {
+		tid int
+		ts  float64
+	}
+	threads := make(map[int]string) // goroutine names
+	labels := make(map[at]string)   // operations, such as "recv queue: 3"
+	flows := make(map[int][]at)     // the send and receive of each value
+	for _, e := range got.TraceEvents {
+		switch e.Ph {
+		case "M":
+			threads[e.Tid] = e.Args["name"]
+		case "X":
+			labels[at{e.Tid, e.Ts}] = e.Name
+		case "s", "f":
+			if e.Name == "queue" {
+				flows[e.ID] = append(flows[e.ID], at{e.Tid, e.Ts})
+			}
+		}
+	}
+
+	workers := 0
+	for _, name := range threads {
+		if strings.HasPrefix(name, "worker") {
+			workers++
+		}
+	}
+	if workers != 4 {
+		t.Errorf("got %d workers in the trace, want 4", workers)
+	}
+	if len(flows) != 60 {
+		t.Errorf("got %d jobs sent and received, want 60", len(flows))
+	}
+	for _, f := range flows {
+		send, recv := labels[f[0]], labels[f[1]]
+		var job int
+		fmt.Sscanf(recv, "recv queue: %d", &job)
+		if send != strings.Replace(recv, "recv", "send", 1) || threads[f[0].tid] != fmt.Sprint("submitter", job/100) {
+			t.Errorf("%q by %s is paired with %q", send, threads[f[0].tid], recv)
+		}
+	}
+}
+
+func TestTraceFlag(t *testing.T) {
+	setFlag(t, "trace", "off")
+	if tr, err := newTracer(); tr != nil || err != nil {
+		t.Errorf("-trace=off: got %v, %v", tr, err)
+	}
+	setFlag(t, "trace", "svg")
+	if tr, err := newTracer(); tr == nil || err != nil {
+		t.Errorf("-trace=svg: got %v, %v", tr, err)
+	}
+	setFlag(t, "trace", "png")
+	if _, err := newTracer(); err == nil {
+		t.Error("-trace=png: no error")
+	}
+}
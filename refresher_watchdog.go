@@ -0,0 +1,216 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/fakhir/learngo/internal/stacks"
+)
+
+var watchdogTimeout = flag.Duration("watchdog", 10*time.Second, "give up on a section whose goroutines are all blocked and which prints nothing for this long, and show where they are blocked (0 disables)")
+
+// runWatched runs the section s like s.run, but gives up on it when it is
+// stuck, for instance because of a deadlock, or when it panics. It then
+// reports through env where the goroutines of the section are, and returns
+// false so that the next section can run. It also returns false if the
+// section failed, whose errors were printed by env.Fail.
+//
+// A section is stuck when it printed nothing for timeout and none of the
+// goroutines it started is running, or waiting for a system call or for the
+// network: a section which computes or runs commands for long is not given up
+// on. The goroutines of a section which is given up on are left blocked, with
+// an environment of their own which prints nothing anymore.
+func runWatched(env *Env, s section, timeout time.Duration) bool {
+	before := make(map[int]bool)
+	for _, g := range stacks.All() {
+		before[g.ID] = true
+	}
+	senv, detach := env.watchedEnv()
+
+	type result struct {
+		err      error
+		panicked bool
+		value    interface{}
+		stack    []byte
+	}
+	done := make(chan result, 1)
+	go func() {
+		panicked := true
+		defer func() {
+			if panicked {
+				done <- result{nil, true, recover(), debug.Stack()}
+			}
+		}()
+		err := s.run(senv)
+		panicked = false
+		done <- result{err: err}
+	}()
+
+	// The watchdog uses the real time: the virtual clock of a deterministic
+	// env only advances when the section sleeps.
+	last, lastProgress := senv.progress.Load(), time.Now()
+	ticker := time.NewTicker(max(timeout/10, time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case r := <-done:
+			if r.panicked {
+				env.Println("Watchdog:", s.name, "panicked:", r.value)
+				for _, g := range stacks.Parse(r.stack) {
+					env.Print(describeGoroutine(g, false))
+				}
+			}
+			return !r.panicked && r.err == nil
+		case now := <-ticker.C:
+			if n := senv.progress.Load(); n != last {
+				last, lastProgress = n, now
+				continue
+			}
+			if now.Sub(lastProgress) < timeout {
+				continue
+			}
+			var started []stacks.Goroutine
+			for _, g := range stacks.All() {
+				if !before[g.ID] {
+					started = append(started, g)
+				}
+			}
+			if working(started) {
+				lastProgress = now
+				continue
+			}
+			detach()
+			env.Println("Watchdog:", s.name, "printed nothing for", timeout, "with all its goroutines blocked, so it is skipped. Its goroutines are:")
+			for _, g := range started {
+				env.Print(describeGoroutine(g, true))
+			}
+			return false
+		}
+	}
+}
+
+// workingStates are the states of goroutines which make progress without
+// printing.
+var workingStates = map[string]bool{
+	"running":  true,
+	"runnable": true,
+	"syscall":  true, // such as waiting for a command
+	"IO wait":  true, // such as waiting for the network
+}
+
+// working reports whether any of the goroutines is working.
+func working(goroutines []stacks.Goroutine) bool {
+	for _, g := range goroutines {
+		if workingStates[g.State] {
+			return true
+		}
+	}
+	return false
+}
+
+// watchedEnv returns the environment of a section run by runWatched. It
+// shares the settings and the output of env, but not the state of the running
+// section, until detach is called: from then on, it prints nothing. A section
+// given up on thus neither prints into the next one nor resets its state.
+func (env *Env) watchedEnv() (senv *Env, detach func()) {
+	out := &detachableWriter{env: env}
+	senv = &Env{
+		clock:      env.clock,
+		rand:       env.rand,
+		goos:       env.goos,
+		maxProcs:   env.maxProcs,
+		out:        out,
+		json:       env.json,
+		section:    env.section,
+		showSource: env.showSource,
+		trace:      env.trace,
+	}
+	if env.onLine != nil {
+		senv.onLine = func(l sourceLine) {
+			out.do(func() { env.onLine(l) })
+		}
+	}
+	return senv, out.detach
+}
+
+// detachableWriter writes to the output of env until it is detached.
+type detachableWriter struct {
+	env      *Env
+	detached bool // guarded by env.mu
+}
+
+func (w *detachableWriter) Write(p []byte) (int, error) {
+	w.do(func() { w.env.out.Write(p) })
+	return len(p), nil
+}
+
+// do calls write with the output of env locked, unless w is detached.
+func (w *detachableWriter) do(write func()) {
+	w.env.mu.Lock()
+	defer w.env.mu.Unlock()
+	if !w.detached {
+		write()
+	}
+}
+
+func (w *detachableWriter) detach() {
+	w.env.mu.Lock()
+	defer w.env.mu.Unlock()
+	w.detached = true
+}
+
+// blockedOn explains the states of goroutines which wait for something.
+var blockedOn = map[string]string{
+	"chan receive":            "receiving from a channel",
+	"chan send":               "sending to a channel",
+	"chan receive (nil chan)": "receiving from a nil channel, which blocks forever",
+	"chan send (nil chan)":    "sending to a nil channel, which blocks forever",
+	"select":                  "a select statement",
+	"select (no cases)":       "an empty select statement, which blocks forever",
+	"sync.Mutex.Lock":         "locking a sync.Mutex",
+	"sync.RWMutex.Lock":       "locking a sync.RWMutex",
+	"sync.RWMutex.RLock":      "read locking a sync.RWMutex",
+	"sync.WaitGroup.Wait":     "waiting for a sync.WaitGroup",
+	"sync.Cond.Wait":          "waiting for a sync.Cond",
+	"semacquire":              "a semaphore of the sync package",
+	"sleep":                   "sleeping",
+}
+
+// describeGoroutine explains where the goroutine is in the notes, with the
+// source of the statement, followed by its full stack if withStack is set.
+func describeGoroutine(g stacks.Goroutine, withStack bool) string {
+	var b strings.Builder
+	what := blockedOn[g.State]
+	if what == "" {
+		what = g.State
+	}
+	fmt.Fprintf(&b, "Watchdog: goroutine %d is %s", g.ID, what)
+	for _, f := range g.Frames {
+		if filepath.Base(f.File) != refresherFile {
+			continue
+		}
+		where := "in"
+		if f.Created {
+			where = "started by"
+		}
+		// Drop the package, which is the import path in the tests.
+		function := f.Function[strings.LastIndexByte(f.Function, '/')+1:]
+		_, function, _ = strings.Cut(function, ".")
+		fmt.Fprintf(&b, " %s %s at %s:%d", where, function, refresherFile, f.Line)
+		if src, err := loadSource(); err == nil && f.Line <= len(src.lines) {
+			fmt.Fprintf(&b, ":\n%6d | %s", f.Line, strings.TrimSpace(src.lines[f.Line-1]))
+		}
+		break
+	}
+	b.WriteString("\n")
+	if withStack {
+		for _, line := range strings.Split(g.Text, "\n") {
+			b.WriteString("    " + line + "\n")
+		}
+	}
+	return b.String()
+}
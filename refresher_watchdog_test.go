@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fakhir/learngo/internal/leaktest"
+	"github.com/fakhir/learngo/internal/stacks"
+)
+
+// runWatchedOutput runs s under the watchdog in a deterministic environment.
+func runWatchedOutput(s section, timeout time.Duration) (bool, string) {
+	var out strings.Builder
+	env := newDeterministicEnv(deterministicSeed, deterministicNow)
+	env.out = &out
+	ok := runWatched(env, s, timeout)
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	return ok, out.String()
+}
+
+// TestWatchdogDeadlocks runs the deadlock sections, whose goroutines stay
+// blocked until the tests end.
+func TestWatchdogDeadlocks(t *testing.T) {
+	want := map[string][]string{
+		"deadlockReadTooMany": {
+			"is receiving from a channel in deadlockReadTooMany at refresher.go:",
+			"| total += <-numberChan",
+		},
+		"deadlockLockTwice": {
+			"is locking a sync.Mutex in deadlockLockTwice at refresher.go:",
+		},
+		"deadlockWaitForEachOther": {
+			"is waiting for a sync.WaitGroup in deadlockWaitForEachOther",
+			`| ping <- "ping"`,
+			`| pong <- "pong"`,
+		},
+	}
+	for _, s := range sections {
+		if !s.manual {
+			continue
+		}
+		t.Run(s.name, func(t *testing.T) {
+			ok, out := runWatchedOutput(s, 100*time.Millisecond)
+			if ok {
+				t.Error("the watchdog did not give up")
+			}
+			if !strings.Contains(out, "Watchdog: "+s.name+" printed nothing for 100ms") {
+				t.Errorf("no report in the output:\n%s", out)
+			}
+			for _, w := range want[s.name] {
+				if !strings.Contains(out, w) {
+					t.Errorf("output does not contain %q:\n%s", w, out)
+				}
+			}
+		})
+	}
+}
+
+func TestWatchdogPanic(t *testing.T) {
+	s := section{name: "panics", fn: func(env *Env) {
+		var m map[string]int
+		m["boom"] = 1
+	}}
+	ok, out := runWatchedOutput(s, time.Second)
+	if ok {
+		t.Error("a panic was not reported as a failure")
+	}
+	if !strings.Contains(out, "Watchdog: panics panicked: assignment to entry in nil map") {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+}
+
+// TestWatchdogFail checks that a section which fails runs to its end and is
+// reported as a failure with all its errors.
+func TestWatchdogFail(t *testing.T) {
+	s := section{name: "fails", fn: func(env *Env) {
+		env.Fail(errors.New("first"))
+		env.Fail(errors.New("second"))
+		env.Println("Fails: done")
+	}}
+	ok, out := runWatchedOutput(s, time.Second)
+	if ok {
+		t.Error("the failure was not reported")
+	}
+	// Each error is printed once, by Fail.
+	want := "Error: first\nError: second\nFails: done\n"
+	if out != want {
+		t.Errorf("got output:\n%s\nwant:\n%s", out, want)
+	}
+}
+
+func TestWatchdogProgress(t *testing.T) {
+	leaktest.Check(t)
+	// The section takes longer than the timeout, but keeps printing.
+	s := section{name: "slow", fn: func(env *Env) {
+		for i := 0; i < 6; i++ {
+			time.Sleep(20 * time.Millisecond)
+			env.Println("Slow:", i)
+		}
+	}}
+	ok, out := runWatchedOutput(s, 50*time.Millisecond)
+	if !ok || strings.Contains(out, "Watchdog") || !strings.HasSuffix(out, "Slow: 5\n") {
+		t.Errorf("got %v, output:\n%s", ok, out)
+	}
+}
+
+// TestWatchdogWorking checks that a section which computes without printing
+// is not given up on.
+func TestWatchdogWorking(t *testing.T) {
+	s := section{name: "silent", fn: func(env *Env) {
+		for start := time.Now(); time.Since(start) < 200*time.Millisecond; {
+		}
+		env.Println("Silent: done")
+	}}
+	ok, out := runWatchedOutput(s, 50*time.Millisecond)
+	if !ok || out != "Silent: done\n" {
+		t.Errorf("got %v, output:\n%s", ok, out)
+	}
+}
+
+// TestWatchdogDetach checks that a section which is given up on, and goes on
+// later, neither prints nor fails the next section.
+func TestWatchdogDetach(t *testing.T) {
+	var out strings.Builder
+	env := newDeterministicEnv(deterministicSeed, deterministicNow)
+	env.out = &out
+	release := make(chan bool)
+	stuck := section{name: "stuck", fn: func(env *Env) {
+		<-release
+		env.Println("Stuck: late")
+		env.Fail(errors.New("late"))
+	}}
+	next := section{name: "next", fn: func(env *Env) {
+		close(release)
+		time.Sleep(50 * time.Millisecond) // lets stuck go on
+		env.Println("Next: done")
+	}}
+	before := leaktest.Goroutines()
+	if runWatched(env, stuck, 50*time.Millisecond) {
+		t.Error("the watchdog did not give up")
+	}
+	if !runWatched(env, next, time.Second) {
+		t.Error("the next section failed")
+	}
+	if leaked := leaktest.Leaked(before, leaktest.Timeout); len(leaked) > 0 {
+		t.Fatalf("stuck did not end:\n%s", strings.Join(leaked, "\n\n"))
+	}
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	if got := out.String(); strings.Contains(got, "late") || !strings.HasSuffix(got, "Next: done\n") {
+		t.Errorf("got output:\n%s", got)
+	}
+}
+
+func TestDescribeGoroutine(t *testing.T) {
+	g := stacks.Parse([]byte(`goroutine 9 [select]:
+main.moreOnChannels.func2()
+	/src/pipeline/pipeline.go:30 +0x45
+created by main.moreOnChannels in goroutine 7
+	/src/refresher.go:2 +0x10b
+`))[0]
+	if d := describeGoroutine(g, false); !strings.HasPrefix(d, "Watchdog: goroutine 9 is a select statement started by moreOnChannels at refresher.go:2:") {
+		t.Errorf("got description %q", d)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"unicode"
+)
+
+// wcCounts are the counts of an input of wc.
+type wcCounts struct {
+	lines, words, chars, bytes int64
+}
+
+func (c *wcCounts) add(o wcCounts) {
+	c.lines += o.lines
+	c.words += o.words
+	c.chars += o.chars
+	c.bytes += o.bytes
+}
+
+// isWordSeparator reports whether GNU wc ends a word at r: at white space as
+// defined by the C library, which unlike unicode.IsSpace excludes U+0085 and
+// the line and paragraph separators, and at the no-break spaces, U+2060 WORD
+// JOINER included.
+func isWordSeparator(r rune) bool {
+	switch r {
+	case '\u0085', '\u2028', '\u2029':
+		return false
+	case '\u2060':
+		return true
+	}
+	return unicode.IsSpace(r)
+}
+
+// isWordChar reports whether GNU wc starts a word at r, which it does for the
+// printable characters. Control characters and unassigned code points neither
+// start nor end a word.
+func isWordChar(r rune) bool {
+	return unicode.IsGraphic(r) || unicode.In(r, unicode.Cf, unicode.Co)
+}
+
+// decodeChar decodes the character at the start of p like the UTF-8 locales
+// of glibc, on which GNU wc relies: unlike utf8.DecodeRune, they accept the
+// code points above U+10FFFF, up to 0x7FFFFFFF in sequences of up to 6 bytes,
+// but still reject overlong sequences and surrogates. It returns a negative r
+// and size 1 for an invalid byte, and size 0 if p ends within a character.
+func decodeChar(p []byte) (r rune, size int) {
+	var min rune // the smallest code point of a sequence of size bytes
+	switch b := p[0]; {
+	case b < 0x80:
+		return rune(b), 1
+	case b < 0xc0:
+		return -1, 1 // a continuation byte
+	case b < 0xe0:
+		r, size, min = rune(b&0x1f), 2, 0x80
+	case b < 0xf0:
+		r, size, min = rune(b&0x0f), 3, 0x800
+	case b < 0xf8:
+		r, size, min = rune(b&0x07), 4, 0x10000
+	case b < 0xfc:
+		r, size, min = rune(b&0x03), 5, 0x200000
+	case b < 0xfe:
+		r, size, min = rune(b&0x01), 6, 0x4000000
+	default:
+		return -1, 1
+	}
+	for i := 1; i < size; i++ {
+		if i == len(p) {
+			return 0, 0
+		}
+		if p[i]&0xc0 != 0x80 {
+			return -1, 1
+		}
+		r = r<<6 | rune(p[i]&0x3f)
+	}
+	if r < min || 0xd800 <= r && r <= 0xdfff {
+		return -1, 1
+	}
+	return r, size
+}
+
+// countWC counts the lines, words, characters and bytes of r. A line is
+// counted for each newline, and a character for each sequence accepted by
+// decodeChar: invalid bytes are only counted as bytes.
+func countWC(r io.Reader) (wcCounts, error) {
+	var counts wcCounts
+	inWord := false
+	var pending []byte // the start of a character split between two pieces of a long line
+	_, err := readLines(bufio.NewReaderSize(r, 32*1024), func(line []byte) {
+		counts.bytes += int64(len(line))
+		data := line
+		if len(pending) > 0 {
+			data = append(pending, line...)
+		}
+		i := 0
+		for i < len(data) {
+			c, size := decodeChar(data[i:])
+			if size == 0 {
+				break // the rest of the character is in the next piece, if any
+			}
+			i += size
+			if c < 0 {
+				continue
+			}
+			counts.chars++
+			switch {
+			case c == '\n':
+				counts.lines++
+				fallthrough
+			case isWordSeparator(c):
+				inWord = false
+			case isWordChar(c) && !inWord:
+				counts.words++
+				inWord = true
+			}
+		}
+		pending = append(pending[:0], data[i:]...)
+	})
+	return counts, err
+}
+
+// wcOptions selects the counts printed by wc, always in this order.
+type wcOptions struct {
+	lines, words, chars, bytes bool
+}
+
+// wcWidth returns the width of the columns of the counts, computed like GNU wc
+// before reading any input: the number of digits of the total size of the
+// regular files, or at least 7 if an input is not a regular file and its size
+// is thus unknown. A single count of a single input is not aligned.
+func wcWidth(in commandInputs, names []string, opts wcOptions) int {
+	selected := 0
+	for _, b := range []bool{opts.lines, opts.words, opts.chars, opts.bytes} {
+		if b {
+			selected++
+		}
+	}
+	if len(names) == 1 && selected == 1 {
+		return 1
+	}
+	minWidth, total := 1, int64(0)
+	for _, name := range names {
+		size, regular, err := in.size(name)
+		switch {
+		case err != nil:
+			// It is reported when wc fails to read it.
+		case regular:
+			total += size
+		default:
+			minWidth = 7
+		}
+	}
+	return max(minWidth, len(strconv.FormatInt(total, 10)))
+}
+
+// writeWC prints the selected counts of the input name.
+func writeWC(w io.Writer, counts wcCounts, opts wcOptions, width int, name string) {
+	sep := ""
+	for _, c := range []struct {
+		selected bool
+		n        int64
+	}{
+		{opts.lines, counts.lines},
+		{opts.words, counts.words},
+		{opts.chars, counts.chars},
+		{opts.bytes, counts.bytes},
+	} {
+		if c.selected {
+			fmt.Fprintf(w, "%s%*d", sep, width, c.n)
+			sep = " "
+		}
+	}
+	if name != "" {
+		fmt.Fprintf(w, " %s", name)
+	}
+	fmt.Fprintln(w)
+}
+
+// wcFiles prints the counts of the named files, or of stdin if there are none,
+// and their total if there are several, to stdout. It reports the files it
+// cannot read to stderr and returns errReported if there were any.
+func wcFiles(in commandInputs, names []string, opts wcOptions, stdout, stderr io.Writer) error {
+	if opts == (wcOptions{}) {
+		opts = wcOptions{lines: true, words: true, bytes: true}
+	}
+	// stdin is unnamed unless it is given as "-".
+	label := func(name string) string { return name }
+	if len(names) == 0 {
+		names = []string{"-"}
+		label = func(string) string { return "" }
+	}
+	width := wcWidth(in, names, opts)
+
+	w := bufio.NewWriter(stdout)
+	var total wcCounts
+	failed := false
+	for _, name := range names {
+		f, err := in.open(name)
+		opened := err == nil
+		var counts wcCounts
+		if opened {
+			counts, err = countWC(f)
+			f.Close()
+		}
+		if err != nil {
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			fmt.Fprintf(stderr, "wc: %s: %s\n", name, errorText(err))
+			failed = true
+			if !opened {
+				continue // GNU wc still prints the counts of a file it opened
+			}
+		}
+		writeWC(w, counts, opts, width, label(name))
+		total.add(counts)
+	}
+	if len(names) > 1 {
+		writeWC(w, total, opts, width, "total")
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if failed {
+		return errReported
+	}
+	return nil
+}
+
+// wcCommand implements "refresher wc".
+func wcCommand(env *Env, args []string) error {
+	in, err := newCommandInputs()
+	if err != nil {
+		return err
+	}
+	defer closeFS(in.fsys)
+	return runWC(in, args, os.Stdout, os.Stderr)
+}
+
+// runWC runs wc with the command line args.
+func runWC(in commandInputs, args []string, stdout, stderr io.Writer) error {
+	var opts wcOptions
+	files, err := parseOptions("wc", args, []gnuOption{
+		{'c', "bytes", "print the byte counts", func() { opts.bytes = true }},
+		{'m', "chars", "print the character counts", func() { opts.chars = true }},
+		{'l', "lines", "print the newline counts", func() { opts.lines = true }},
+		{'w', "words", "print the word counts", func() { opts.words = true }},
+	}, stdout, stderr)
+	if err == flag.ErrHelp {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return wcFiles(in, files, opts, stdout, stderr)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fakhir/learngo/semaphore"
+)
+
+// webSectionTimeout limits how long a section may run for a web request.
+const webSectionTimeout = 30 * time.Second
+
+// webMaxRuns limits how many sections may run at the same time for web
+// requests, including those which timed out but are still running.
+const webMaxRuns = 4
+
+// webRuns counts the sections running for web requests, up to webMaxRuns.
+var webRuns = semaphore.NewWeighted(webMaxRuns)
+
+// errTooManyRuns is returned by runCaptured when webMaxRuns sections are
+// already running.
+var errTooManyRuns = fmt.Errorf("%d sections are already running, try again later", webMaxRuns)
+
+// sectionResult is the outcome of running a section from the web UI.
+type sectionResult struct {
+	Name          string `json:"name"`
+	Deterministic bool   `json:"deterministic"`
+	Output        string `json:"output"`
+	Source        string `json:"source,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// webSections returns the sections which can run from the web UI: anyone who
+// can reach the server runs them, so the sections which run commands, read
+// files, use the network, change the state of the process or leak goroutines
+// are left out.
+func webSections() []section {
+	var list []section
+	for _, s := range sections {
+		if !s.interactive && !s.manual && !s.external && !s.global && !s.leaks {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// handleSections lists the sections as an HTML page, or as JSON with
+// format=json.
+func handleSections(w http.ResponseWriter, r *http.Request) {
+	var names []string
+	for _, s := range webSections() {
+		names = append(names, s.name)
+	}
+	if r.FormValue("format") == "json" {
+		writeJSON(w, http.StatusOK, names)
+		return
+	}
+	sectionsTempl.Execute(w, names)
+}
+
+// handleSection runs the section named in the path and shows its output and
+// source as an HTML page, or as JSON with format=json. The section runs in a
+// deterministic environment with deterministic=true.
+func handleSection(w http.ResponseWriter, r *http.Request) {
+	var s section
+	found := false
+	for _, ws := range webSections() {
+		if ws.name == r.PathValue("name") {
+			s, found = ws, true
+		}
+	}
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+
+	result := sectionResult{Name: s.name, Source: funcSource(s.fn)}
+	env := newEnv()
+	if result.Deterministic = r.FormValue("deterministic") == "true"; result.Deterministic {
+		env = newDeterministicEnv(deterministicSeed, deterministicNow)
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), webSectionTimeout)
+	defer cancel()
+	var err error
+	result.Output, err = runCaptured(ctx, s, env)
+	status := http.StatusOK
+	if errors.Is(err, errTooManyRuns) {
+		result.Error = err.Error()
+		status = http.StatusServiceUnavailable
+	} else if err != nil {
+		result.Error = err.Error()
+		status = http.StatusInternalServerError
+	}
+
+	if r.FormValue("format") == "json" {
+		writeJSON(w, status, result)
+		return
+	}
+	w.WriteHeader(status)
+	sectionTempl.Execute(w, result)
+}
+
+// runCaptured runs s in env and returns what it printed. Every run has its
+// own env and output, so that concurrent runs do not mix. The run is abandoned
+// when ctx is done, returning the output so far, and a panic of the section is
+// returned as an error, like the failures of the section.
+//
+// At most webMaxRuns sections run at the same time: runCaptured returns
+// errTooManyRuns beyond. An abandoned run goes on until the section returns,
+// which cannot be interrupted, and counts against the limit until then.
+func runCaptured(ctx context.Context, s section, env *Env) (string, error) {
+	if !webRuns.TryAcquire(1) {
+		return "", errTooManyRuns
+	}
+	var out strings.Builder
+	env.out = &out
+	done := make(chan error, 1)
+	go func() {
+		defer webRuns.Release(1)
+		defer func() {
+			if value := recover(); value != nil {
+				done <- fmt.Errorf("section %s panicked: %v", s.name, value)
+			}
+		}()
+		done <- s.run(env)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("section %s: %v; it still runs in the background, and counts against the limit of %d runs", s.name, ctx.Err(), webMaxRuns)
+	}
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	return out.String(), err
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+var sectionsTempl = template.Must(template.New("sections").Parse(`<html>
+<head>
+<title>Golang Refresher</title>
+</head>
+<body>
+<h1>Golang Refresher</h1>
+<ul>
+{{range .}}<li><a href="/sections/{{.}}">{{.}}</a> (<a href="/sections/{{.}}?deterministic=true">deterministic</a>)</li>
+{{end}}</ul>
+<a href="/sections?format=json">JSON</a>
+</body>
+</html>
+`))
+
+var sectionTempl = template.Must(template.New("section").Parse(`<html>
+<head>
+<title>{{.Name}} - Golang Refresher</title>
+</head>
+<body>
+<h1>{{.Name}}</h1>
+<a href="/sections">All sections</a>
+<a href="?deterministic={{.Deterministic}}">Run again</a>
+<a href="?deterministic={{.Deterministic}}&amp;format=json">JSON</a>
+{{if .Error}}<p><b>Error:</b> {{.Error}}</p>{{end}}
+<h2>Output</h2>
+<pre>{{.Output}}</pre>
+{{if .Source}}<h2>Source</h2>
+<pre>{{.Source}}</pre>{{end}}
+</body>
+</html>
+`))
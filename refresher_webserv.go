@@ -2,14 +2,31 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"flag"
 	"fmt"
+	"html/template"
+	"log"
+	"net"
 	"net/http"
 	"os"
-	"text/template"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fakhir/learngo/qr"
 )
 
-var addr = flag.String("addr", ":1718", "http service address (port)")
+var (
+	addr            = flag.String("addr", ":1718", "http service address (port)")
+	readTimeout     = flag.Duration("read-timeout", 10*time.Second, "maximum duration for reading a request")
+	writeTimeout    = flag.Duration("write-timeout", webSectionTimeout+10*time.Second, "maximum duration for writing a response")
+	idleTimeout     = flag.Duration("idle-timeout", 2*time.Minute, "maximum duration to keep an idle connection open")
+	shutdownTimeout = flag.Duration("shutdown-timeout", 10*time.Second, "maximum duration to wait for requests in progress when shutting down")
+	prompt          = flag.Bool("prompt", false, "also stop the web server when enter is pressed")
+)
 
 var templ = template.Must(template.New("qr").Parse(templateStr))
 
@@ -17,21 +34,185 @@ func handleHello(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("Hello world!"))
 }
 
+// handleQr shows a form to enter a text and, once submitted, its QR code.
 func handleQr(w http.ResponseWriter, r *http.Request) {
-	w.Write([]byte("Hello QR"))
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	level := r.FormValue("level")
+	if _, err := qr.ParseLevel(level); err != nil {
+		level = qr.M.String()
+	}
+	templ.Execute(w, struct {
+		Text, Level string
+		Levels      []qr.Level
+	}{r.FormValue("s"), level, []qr.Level{qr.L, qr.M, qr.Q, qr.H}})
 }
 
-func setupWebserv() {
-	flag.Parse()
-	http.HandleFunc("/hello", http.HandlerFunc(handleHello))
-	http.HandleFunc("/", http.HandlerFunc(handleQr))
-	go http.ListenAndServe(*addr, nil)
+// handleQrImage renders the QR code of the "s" parameter as a PNG or SVG
+// image, depending on the extension of the path. The optional "level" and
+// "scale" parameters select the error correction level and the pixels per
+// module.
+func handleQrImage(w http.ResponseWriter, r *http.Request) {
+	level := qr.M
+	if s := r.FormValue("level"); s != "" {
+		var err error
+		if level, err = qr.ParseLevel(s); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	scale := 8
+	if s := r.FormValue("scale"); s != "" {
+		var err error
+		if scale, err = strconv.Atoi(s); err != nil || scale < 1 || scale > 32 {
+			http.Error(w, "scale must be between 1 and 32", http.StatusBadRequest)
+			return
+		}
+	}
+	code, err := qr.Encode([]byte(r.FormValue("s")), level)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if r.URL.Path == "/qr.svg" {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		code.WriteSVG(w, scale)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	code.WritePNG(w, scale)
+}
+
+// stdinLines returns the lines of stdin, which a single goroutine reads, and
+// is closed at the end of stdin. A read of stdin cannot be interrupted, so
+// the goroutine is shared: whoever waits for a line can give up without
+// leaving a reader blocked behind.
+var stdinLines = sync.OnceValue(func() <-chan string {
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		sc := bufio.NewScanner(os.Stdin)
+		for sc.Scan() {
+			lines <- sc.Text()
+		}
+	}()
+	return lines
+})
+
+// setupWebserv runs the web server until the process receives SIGINT or
+// SIGTERM, or enter is pressed with -prompt.
+func setupWebserv(env *Env) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *prompt {
+		env.Print("Press enter to exit...")
+		go func() {
+			select {
+			case <-stdinLines():
+				stop()
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	srv, err := newServer()
+	if err != nil {
+		env.Fail(err)
+		return
+	}
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		env.Fail(err) // most likely, the port is taken
+		return
+	}
+	env.Println("Webserv: listening on", ln.Addr())
+	if err := serve(ctx, srv, ln, *shutdownTimeout); err != nil {
+		env.Fail(err)
+		return
+	}
+	env.Println("Webserv: stopped")
+}
+
+// newServer returns the web server configured by the flags.
+func newServer() (*http.Server, error) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hello", handleHello)
+	mux.HandleFunc("/", handleQr)
+	mux.HandleFunc("/qr.png", handleQrImage)
+	mux.HandleFunc("/qr.svg", handleQrImage)
+	mux.HandleFunc("GET /sections", handleSections)
+	mux.HandleFunc("GET /sections/{name...}", handleSection)
+
+	logger := log.New(os.Stderr, "", 0)
+	middlewares, err := webMiddlewares(logger)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Server{
+		Addr:         *addr,
+		Handler:      chain(mux, middlewares...),
+		ReadTimeout:  *readTimeout,
+		WriteTimeout: *writeTimeout,
+		IdleTimeout:  *idleTimeout,
+		ErrorLog:     logger,
+	}, nil
+}
+
+// serve serves HTTP requests on ln until ctx is done. It then stops accepting
+// connections and waits up to drain for the requests in progress, after which
+// the remaining connections are closed.
+func serve(ctx context.Context, srv *http.Server, ln net.Listener, drain time.Duration) error {
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
 
-	fmt.Print("Press enter to exit...")
-	waitForKey := bufio.NewReader(os.Stdin)
-	waitForKey.ReadString('\n')
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), drain)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		srv.Close()
+		<-errc
+		return fmt.Errorf("shutting down: %v", err)
+	}
+	if err := <-errc; err != http.ErrServerClosed {
+		return err
+	}
+	return nil
 }
 
-var templateStr string = `
-hello hello
+// templateStr is the page of the QR code generator from Effective Go, which
+// renders the codes locally instead of using the Google chart service.
+var templateStr string = `<html>
+<head>
+<title>QR Link Generator</title>
+</head>
+<body>
+{{if .Text}}
+<img src="/qr.svg?s={{.Text}}&level={{.Level}}" width="300" height="300" alt="QR code" />
+<br>
+{{.Text}}
+<br>
+<a href="/qr.png?s={{.Text}}&level={{.Level}}">PNG</a>
+<a href="/qr.svg?s={{.Text}}&level={{.Level}}">SVG</a>
+<br>
+<br>
+{{end}}
+<form action="/" name=f method="GET">
+    <input maxLength=1024 size=70 name=s value="{{.Text}}" title="Text to QR Encode">
+    <select name=level title="Error correction level">
+    {{range .Levels}}<option{{if eq .String $.Level}} selected{{end}}>{{.}}</option>{{end}}
+    </select>
+    <input type=submit value="Show QR" name=qr>
+</form>
+</body>
+</html>
 `
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"image/png"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHandleQr(t *testing.T) {
+	w := httptest.NewRecorder()
+	handleQr(w, httptest.NewRequest("GET", "/?s=a%26b&level=H", nil))
+	body := w.Body.String()
+	if !strings.Contains(body, `<img src="/qr.svg?s=a%26b&level=H"`) {
+		t.Errorf("page does not show the code:\n%s", body)
+	}
+	if !strings.Contains(body, "<option selected>H</option>") {
+		t.Errorf("page does not keep the level:\n%s", body)
+	}
+}
+
+func TestHandleQrImage(t *testing.T) {
+	w := httptest.NewRecorder()
+	handleQrImage(w, httptest.NewRequest("GET", "/qr.png?s=hello&scale=2", nil))
+	if ct := w.Header().Get("Content-Type"); ct != "image/png" {
+		t.Fatalf("got content type %q", ct)
+	}
+	img, err := png.Decode(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Version 1 codes have 21 modules per side, plus the quiet zone.
+	if got := img.Bounds().Dx(); got != (21+8)*2 {
+		t.Errorf("got %d pixels per side", got)
+	}
+
+	w = httptest.NewRecorder()
+	handleQrImage(w, httptest.NewRequest("GET", "/qr.svg?s=hello", nil))
+	if ct := w.Header().Get("Content-Type"); ct != "image/svg+xml" || !strings.Contains(w.Body.String(), "<svg") {
+		t.Errorf("got %q:\n%s", ct, w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	handleQrImage(w, httptest.NewRequest("GET", "/qr.png?s=hello&level=X", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid level: got status %d", w.Code)
+	}
+}
+
+// newSectionsHandler returns the handler of the web server.
+func newSectionsHandler(t *testing.T) http.Handler {
+	flag.Set("access-log", "off")
+	srv, err := newServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv.Handler
+}
+
+func TestHandleSections(t *testing.T) {
+	w := httptest.NewRecorder()
+	newSectionsHandler(t).ServeHTTP(w, httptest.NewRequest("GET", "/sections?format=json", nil))
+	var names []string
+	if err := json.Unmarshal(w.Body.Bytes(), &names); err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != len(webSections()) || names[0] != sections[0].name {
+		t.Errorf("unexpected sections %v", names)
+	}
+	for _, name := range names {
+		if name == "setupWebserv" {
+			t.Error("interactive section listed")
+		}
+		if name == "moreOnChannels/infiniteWriter" {
+			t.Error("leaking section listed")
+		}
+	}
+}
+
+// TestHandleSection runs sections concurrently and checks that their output
+// does not mix.
+func TestHandleSection(t *testing.T) {
+	mux := newSectionsHandler(t)
+	var wg sync.WaitGroup
+	for _, name := range []string{"moreOnSlices", "controlFlow", "moreOnSlices", "controlFlow"} {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest("GET", "/sections/"+name+"?deterministic=true&format=json", nil))
+			var result sectionResult
+			if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+				t.Error(err)
+				return
+			}
+			want, _ := os.ReadFile(filepath.Join("testdata", name+".golden"))
+			if w.Code != http.StatusOK || result.Output != string(want) {
+				t.Errorf("%s: got status %d and output:\n%s", name, w.Code, result.Output)
+			}
+			if !strings.HasPrefix(result.Source, "func "+name+"(") {
+				t.Errorf("%s: unexpected source:\n%s", name, result.Source)
+			}
+		}(name)
+	}
+	wg.Wait()
+
+	// The sections which must not run for any client are not found.
+	for _, name := range []string{"setupWebserv", "execCommands", "communicationInGo", "concurrencyVsParallelism"} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", "/sections/"+name, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s: got status %d", name, w.Code)
+		}
+	}
+}
+
+// TestRunCapturedLimit checks that a run which timed out counts against the
+// limit of concurrent runs until the section returns.
+func TestRunCapturedLimit(t *testing.T) {
+	release := make(chan bool)
+	blocking := section{name: "blocking", fn: func(env *Env) {
+		env.Println("Blocking:started")
+		<-release
+	}}
+	for i := 0; i < webMaxRuns; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		_, err := runCaptured(ctx, blocking, newDeterministicEnv(deterministicSeed, deterministicNow))
+		cancel()
+		if err == nil || !strings.Contains(err.Error(), "still runs in the background") {
+			t.Errorf("got %v, want a timeout", err)
+		}
+	}
+
+	mux := newSectionsHandler(t)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/sections/controlFlow?format=json", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d with %d sections running", w.Code, webMaxRuns)
+	}
+
+	close(release)
+	for !webRuns.TryAcquire(webMaxRuns) {
+		time.Sleep(time.Millisecond)
+	}
+	webRuns.Release(webMaxRuns)
+}
+
+// TestSetupWebservFails checks that setupWebserv fails when it cannot listen.
+func TestSetupWebservFails(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	defer flag.Set("addr", *addr)
+	flag.Set("addr", ln.Addr().String()) // the port is taken
+	flag.Set("access-log", "off")
+
+	var out strings.Builder
+	env := newDeterministicEnv(deterministicSeed, deterministicNow)
+	env.out = &out
+	s := section{name: "setupWebserv", fn: setupWebserv}
+	if err := s.run(env); err == nil || !strings.Contains(out.String(), "Error: ") {
+		t.Errorf("got %v, output %q, want a failure", err, out.String())
+	}
+}
+
+// TestServeShutdown checks that a request in progress completes when the
+// server is shut down, and that no new connections are accepted.
+func TestServeShutdown(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan bool)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- true
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("done"))
+	})}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error)
+	go func() { served <- serve(ctx, srv, ln, time.Second) }()
+
+	url := "http://" + ln.Addr().String()
+	body := make(chan string)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		body <- string(b)
+	}()
+	<-started
+	cancel()
+
+	if got := <-body; got != "done" {
+		t.Errorf("request in progress: got %q", got)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("serve: %v", err)
+	}
+	if _, err := http.Get(url); err == nil {
+		t.Error("server still accepts connections")
+	}
+}
+
+// TestServeDrainTimeout checks that serve gives up on requests which take
+// longer than the drain timeout.
+func TestServeDrainTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan bool)
+	release := make(chan bool)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- true
+		<-release
+	})}
+	defer close(release)
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error)
+	go func() { served <- serve(ctx, srv, ln, 50*time.Millisecond) }()
+
+	go http.Get("http://" + ln.Addr().String())
+	<-started
+	cancel()
+	if err := <-served; err == nil {
+		t.Error("serve did not report the requests it abandoned")
+	}
+}
@@ -0,0 +1,199 @@
+package regex
+
+import "unicode/utf8"
+
+// instOp is the kind of an instruction of a compiled regular expression.
+type instOp int
+
+const (
+	instRune  instOp = iota // consumes a character in ranges and goes on to the next instruction
+	instSplit               // goes on to both x and, with a lower priority, y
+	instJmp                 // goes on to x
+	instBegin               // goes on to the next instruction at the beginning of the text
+	instEnd                 // goes on to the next instruction at the end of the text
+	instMatch               // reports a match
+)
+
+// inst is an instruction of a compiled regular expression. The instructions
+// are the states of the automaton and x and y its transitions which consume no
+// character.
+type inst struct {
+	op     instOp
+	ranges []rune
+	x, y   int
+}
+
+// matches reports whether r is in the ranges of the instruction.
+func (i *inst) matches(r rune) bool {
+	for j := 0; j < len(i.ranges) && i.ranges[j] <= r; j += 2 {
+		if r <= i.ranges[j+1] {
+			return true
+		}
+	}
+	return false
+}
+
+// compile returns the instructions of the automaton matching n.
+func compile(n *node) []inst {
+	c := &compiler{}
+	c.emit(n)
+	c.add(inst{op: instMatch})
+	return c.prog
+}
+
+type compiler struct {
+	prog []inst
+}
+
+// add appends an instruction and returns its index.
+func (c *compiler) add(i inst) int {
+	c.prog = append(c.prog, i)
+	return len(c.prog) - 1
+}
+
+// emit appends the instructions matching n, followed by whatever comes next.
+func (c *compiler) emit(n *node) {
+	switch n.op {
+	case opEmpty:
+	case opClass:
+		c.add(inst{op: instRune, ranges: n.ranges})
+	case opBegin:
+		c.add(inst{op: instBegin})
+	case opEnd:
+		c.add(inst{op: instEnd})
+	case opConcat:
+		for _, sub := range n.subs {
+			c.emit(sub)
+		}
+	case opAlternate:
+		// split L1, L2; L1: sub 1; jmp end; L2: split L2', L3; ... end:
+		var jumps []int
+		for _, sub := range n.subs[:len(n.subs)-1] {
+			split := c.add(inst{op: instSplit})
+			c.prog[split].x = len(c.prog)
+			c.emit(sub)
+			jumps = append(jumps, c.add(inst{op: instJmp}))
+			c.prog[split].y = len(c.prog)
+		}
+		c.emit(n.subs[len(n.subs)-1])
+		for _, jump := range jumps {
+			c.prog[jump].x = len(c.prog)
+		}
+	case opStar:
+		// x* is compiled as (x+)? which is the same, except that a loop of
+		// x* would let an x matching the empty string take the priority
+		// over the rest of the expression.
+		c.emit(&node{op: opQuest, lazy: n.lazy, subs: []*node{{op: opPlus, lazy: n.lazy, subs: n.subs}}})
+	case opPlus:
+		// L1: sub; split L1, end; end:
+		start := len(c.prog)
+		c.emit(n.subs[0])
+		split := c.add(inst{op: instSplit})
+		c.branch(split, start, split+1, n.lazy)
+	case opQuest:
+		// split L1, end; L1: sub; end:
+		split := c.add(inst{op: instSplit})
+		c.emit(n.subs[0])
+		c.branch(split, split+1, len(c.prog), n.lazy)
+	}
+}
+
+// branch makes the split instruction at pc go on to more, which matches one
+// more repetition, and to less, preferring more unless lazy.
+func (c *compiler) branch(pc, more, less int, lazy bool) {
+	if lazy {
+		more, less = less, more
+	}
+	c.prog[pc].x, c.prog[pc].y = more, less
+}
+
+// thread is a path through the automaton, at the instruction pc, of a match
+// which started at start.
+type thread struct {
+	pc, start int
+}
+
+// queue is a list of threads in the order of their priorities, with at most
+// one thread per instruction: a thread reaching an instruction after another
+// one would make the same moves, but with a lower priority.
+type queue struct {
+	threads []thread
+	queued  []bool // the instructions reached, with or without a thread
+	reached []int  // their indices, to clear them
+}
+
+func newQueue(size int) *queue {
+	return &queue{queued: make([]bool, size)}
+}
+
+func (q *queue) clear() {
+	for _, pc := range q.reached {
+		q.queued[pc] = false
+	}
+	q.threads, q.reached = q.threads[:0], q.reached[:0]
+}
+
+// add adds to q the thread at pc, or rather the threads at the instructions
+// consuming a character or matching which it reaches at pos through the
+// transitions consuming no character.
+func (re *Regexp) add(q *queue, pc, pos, start int, s string) {
+	if q.queued[pc] {
+		return
+	}
+	q.queued[pc] = true
+	q.reached = append(q.reached, pc)
+	switch i := &re.prog[pc]; i.op {
+	case instSplit:
+		re.add(q, i.x, pos, start, s)
+		re.add(q, i.y, pos, start, s)
+	case instJmp:
+		re.add(q, i.x, pos, start, s)
+	case instBegin:
+		if pos == 0 {
+			re.add(q, pc+1, pos, start, s)
+		}
+	case instEnd:
+		if pos == len(s) {
+			re.add(q, pc+1, pos, start, s)
+		}
+	default:
+		q.threads = append(q.threads, thread{pc, start})
+	}
+}
+
+// match returns the start and end of the leftmost match of re in s, by
+// advancing all the threads in step over the characters of s.
+func (re *Regexp) match(s string) (start, end int, ok bool) {
+	clist, nlist := newQueue(len(re.prog)), newQueue(len(re.prog))
+	for pos := 0; ; {
+		if !ok {
+			// A new match may start here, with the lowest priority.
+			re.add(clist, 0, pos, pos, s)
+		}
+		if ok && len(clist.threads) == 0 {
+			break
+		}
+		r, width := rune(0), 0
+		if pos < len(s) {
+			r, width = utf8.DecodeRuneInString(s[pos:])
+		}
+		for _, t := range clist.threads {
+			i := &re.prog[t.pc]
+			if i.op == instMatch {
+				// The remaining threads have lower priorities.
+				start, end, ok = t.start, pos, true
+				break
+			}
+			if width > 0 && i.matches(r) {
+				re.add(nlist, t.pc+1, pos+width, t.start, s)
+			}
+		}
+		if pos == len(s) {
+			break
+		}
+		pos += width
+		clist, nlist = nlist, clist
+		nlist.clear()
+	}
+	return start, end, ok
+}
@@ -0,0 +1,296 @@
+package regex
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// op is the kind of a node of a parsed regular expression.
+type op int
+
+const (
+	opEmpty     op = iota // matches the empty string
+	opClass               // matches a character in ranges; a literal is a class of one
+	opBegin               // matches at the beginning of the text
+	opEnd                 // matches at the end of the text
+	opConcat              // matches subs in sequence
+	opAlternate           // matches one of subs
+	opStar                // matches subs[0] zero or more times
+	opPlus                // matches subs[0] one or more times
+	opQuest               // matches subs[0] zero or one time
+)
+
+// node is a parsed regular expression.
+type node struct {
+	op     op
+	ranges []rune // pairs of the lowest and highest characters of a class, sorted
+	lazy   bool   // a repetition prefers fewer repetitions
+	subs   []*node
+}
+
+// The character classes of ., \d, \s and \w.
+var (
+	anyExceptNewline = []rune{0, '\n' - 1, '\n' + 1, unicode.MaxRune}
+	perlClasses      = map[byte][]rune{
+		'd': {'0', '9'},
+		's': {'\t', '\n', '\f', '\r', ' ', ' '},
+		'w': {'0', '9', 'A', 'Z', '_', '_', 'a', 'z'},
+	}
+)
+
+// parser is a recursive descent parser of regular expressions. Each method
+// parses a part of the grammar at pos and advances pos past it:
+//
+//	alternate = concat { "|" concat }
+//	concat    = { repeat }
+//	repeat    = atom [ ( "*" | "+" | "?" ) [ "?" ] ]
+//	atom      = "(" alternate ")" | "[" class "]" | "." | "^" | "$" | escape | character
+type parser struct {
+	expr string
+	pos  int
+}
+
+// error reports a syntax error by panicking with an Error, which Compile
+// recovers.
+func (p *parser) error(msg, expr string) {
+	panic(Error{msg, expr})
+}
+
+// more reports whether there is more to parse.
+func (p *parser) more() bool {
+	return p.pos < len(p.expr)
+}
+
+// next returns the next byte to parse, or 0 at the end.
+func (p *parser) next() byte {
+	if !p.more() {
+		return 0
+	}
+	return p.expr[p.pos]
+}
+
+// isRepeat reports whether c is a repetition operator.
+func isRepeat(c byte) bool {
+	return c != 0 && strings.IndexByte("*+?", c) >= 0
+}
+
+// parse parses the whole expression.
+func (p *parser) parse() *node {
+	n := p.alternate()
+	if p.more() { // only an unmatched ) stops alternate early
+		p.error("unexpected )", p.expr)
+	}
+	return n
+}
+
+func (p *parser) alternate() *node {
+	subs := []*node{p.concat()}
+	for p.next() == '|' {
+		p.pos++
+		subs = append(subs, p.concat())
+	}
+	if len(subs) == 1 {
+		return subs[0]
+	}
+	return &node{op: opAlternate, subs: subs}
+}
+
+func (p *parser) concat() *node {
+	var subs []*node
+	for p.more() && p.next() != '|' && p.next() != ')' {
+		subs = append(subs, p.repeat())
+	}
+	switch len(subs) {
+	case 0:
+		return &node{op: opEmpty}
+	case 1:
+		return subs[0]
+	}
+	return &node{op: opConcat, subs: subs}
+}
+
+func (p *parser) repeat() *node {
+	n := p.atom()
+	if !isRepeat(p.next()) {
+		return n
+	}
+	start := p.pos
+	n = &node{op: map[byte]op{'*': opStar, '+': opPlus, '?': opQuest}[p.next()], subs: []*node{n}}
+	p.pos++
+	if p.next() == '?' {
+		n.lazy = true
+		p.pos++
+	}
+	if isRepeat(p.next()) {
+		p.error("invalid nested repetition operator", p.expr[start:p.pos+1])
+	}
+	return n
+}
+
+func (p *parser) atom() *node {
+	switch c := p.next(); c {
+	case '*', '+', '?':
+		end := p.pos + 1
+		if end < len(p.expr) && p.expr[end] == '?' {
+			end++
+		}
+		p.error("missing argument to repetition operator", p.expr[p.pos:end])
+	case '(':
+		if strings.HasPrefix(p.expr[p.pos:], "(?") {
+			p.error("invalid or unsupported Perl syntax", "(?")
+		}
+		p.pos++
+		n := p.alternate()
+		if !p.more() {
+			p.error("missing closing )", p.expr)
+		}
+		p.pos++
+		return n
+	case '[':
+		return p.class()
+	case '.':
+		p.pos++
+		return &node{op: opClass, ranges: anyExceptNewline}
+	case '^':
+		p.pos++
+		return &node{op: opBegin}
+	case '$':
+		p.pos++
+		return &node{op: opEnd}
+	case '\\':
+		if ranges := p.perlClass(); ranges != nil {
+			return &node{op: opClass, ranges: ranges}
+		}
+		r := p.escape()
+		return &node{op: opClass, ranges: []rune{r, r}}
+	}
+	r, width := utf8.DecodeRuneInString(p.expr[p.pos:])
+	p.pos += width
+	return &node{op: opClass, ranges: []rune{r, r}}
+}
+
+// perlClass parses \d, \s, \w or their negations \D, \S, \W if they are next,
+// and returns their ranges. It returns nil for any other escape.
+func (p *parser) perlClass() []rune {
+	if p.pos+1 >= len(p.expr) {
+		return nil
+	}
+	c := p.expr[p.pos+1]
+	ranges := perlClasses[c|0x20] // lower case
+	if ranges == nil {
+		return nil
+	}
+	p.pos += 2
+	if c < 'a' {
+		return negate(ranges)
+	}
+	return ranges
+}
+
+// escape parses an escape sequence and returns the character it stands for.
+func (p *parser) escape() rune {
+	start := p.pos
+	p.pos++ // \
+	if !p.more() {
+		p.error("trailing backslash at end of expression", "")
+	}
+	r, width := utf8.DecodeRuneInString(p.expr[p.pos:])
+	p.pos += width
+	switch {
+	case r < utf8.RuneSelf && !unicode.IsLetter(r) && !unicode.IsDigit(r):
+		return r // escaped punctuation stands for itself
+	case r == 'n':
+		return '\n'
+	case r == 't':
+		return '\t'
+	case r == 'r':
+		return '\r'
+	}
+	p.error("invalid escape sequence", p.expr[start:p.pos])
+	panic("unreachable")
+}
+
+// class parses a character class such as [a-z_] or [^\d].
+func (p *parser) class() *node {
+	start := p.pos
+	p.pos++ // [
+	negated := p.next() == '^'
+	if negated {
+		p.pos++
+	}
+	var ranges []rune
+	// A ] at the start of the class is a literal character.
+	for first := true; first || p.next() != ']'; first = false {
+		if !p.more() {
+			p.error("missing closing ]", p.expr[start:])
+		}
+		if r := p.perlClass(); r != nil {
+			ranges = append(ranges, r...)
+			continue
+		}
+		rangeStart := p.pos
+		lo := p.classChar()
+		hi := lo
+		if p.next() == '-' && p.pos+1 < len(p.expr) && p.expr[p.pos+1] != ']' {
+			p.pos++
+			hi = p.classChar()
+			if hi < lo {
+				p.error("invalid character class range", p.expr[rangeStart:p.pos])
+			}
+		}
+		ranges = append(ranges, lo, hi)
+	}
+	p.pos++ // ]
+	ranges = normalize(ranges)
+	if negated {
+		ranges = negate(ranges)
+	}
+	return &node{op: opClass, ranges: ranges}
+}
+
+// classChar parses a character of a class, possibly escaped.
+func (p *parser) classChar() rune {
+	if p.next() == '\\' {
+		return p.escape()
+	}
+	r, width := utf8.DecodeRuneInString(p.expr[p.pos:])
+	p.pos += width
+	return r
+}
+
+// normalize sorts ranges and merges those which overlap or touch.
+func normalize(ranges []rune) []rune {
+	pairs := make([][2]rune, 0, len(ranges)/2)
+	for i := 0; i < len(ranges); i += 2 {
+		pairs = append(pairs, [2]rune{ranges[i], ranges[i+1]})
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i][0] < pairs[j][0] })
+	var merged []rune
+	for _, pair := range pairs {
+		if n := len(merged); n > 0 && pair[0] <= merged[n-1]+1 {
+			merged[n-1] = max(merged[n-1], pair[1])
+		} else {
+			merged = append(merged, pair[0], pair[1])
+		}
+	}
+	return merged
+}
+
+// negate returns the ranges of the characters which are not in the normalized
+// ranges.
+func negate(ranges []rune) []rune {
+	var negated []rune
+	lo := rune(0)
+	for i := 0; i < len(ranges); i += 2 {
+		if ranges[i] > lo {
+			negated = append(negated, lo, ranges[i]-1)
+		}
+		lo = ranges[i+1] + 1
+	}
+	if lo <= unicode.MaxRune {
+		negated = append(negated, lo, unicode.MaxRune)
+	}
+	return negated
+}
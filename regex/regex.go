@@ -0,0 +1,99 @@
+// Package regex is a small regular expression engine, implementing the error
+// handling pattern of the errorHandling section: its parser reports a syntax
+// error by panicking with an Error, which Compile recovers into an ordinary
+// error. A user of the package never sees the panic.
+//
+// The syntax is a subset of the one of the regexp package, with the same
+// meaning:
+//
+//	x         the literal character x, or \x for a punctuation character x
+//	\n \t \r  newline, tab and carriage return
+//	.         any character except newline
+//	[abc]     a character class, with ranges such as [a-z] and negated as [^abc]
+//	\d \w \s  digits, word characters and white space; \D \W \S negate them
+//	^ $       the beginning and the end of the text
+//	xy        x followed by y
+//	x|y       x or y, preferring x
+//	(x)       a group
+//	x* x+ x?  zero or more, one or more, zero or one x, preferring more
+//	x*? x+? x??  the same, preferring fewer
+//
+// Counted repetitions such as x{2,3}, flags and capturing submatches are not
+// supported; { and } are literal characters.
+//
+// A Regexp is compiled to a nondeterministic finite automaton, which is
+// simulated in a single pass over the text by following all its possible
+// states at once, as described by Ken Thompson in 1968. The time to match is
+// thus proportional to the product of the lengths of the expression and the
+// text, without the exponential backtracking of some other engines.
+package regex
+
+// Error is a syntax error in a regular expression. It satisfies the error
+// interface. Another way to define it is to embed an error within a struct and
+// to implement a forwarding Error method.
+type Error struct {
+	Msg  string // the description of the error, e.g. "missing closing )"
+	Expr string // the offending part of the expression
+}
+
+func (e Error) Error() string {
+	return "regex: " + e.Msg + ": `" + e.Expr + "`"
+}
+
+// Regexp is a compiled regular expression. It is safe for concurrent use.
+type Regexp struct {
+	expr string
+	prog []inst
+}
+
+// Compile parses a regular expression and returns a Regexp matching it.
+func Compile(expr string) (re *Regexp, err error) {
+	// The parser panics with an Error if there is a syntax error.
+	defer func() {
+		if e := recover(); e != nil {
+			re = nil        // Clear the return value.
+			err = e.(Error) // Will re-panic if not a syntax error.
+		}
+	}()
+	p := &parser{expr: expr}
+	return &Regexp{expr: expr, prog: compile(p.parse())}, nil
+}
+
+// MustCompile is like Compile but panics if the expression cannot be parsed,
+// for regular expressions known to be valid.
+func MustCompile(expr string) *Regexp {
+	re, err := Compile(expr)
+	if err != nil {
+		panic(err)
+	}
+	return re
+}
+
+// String returns the source text of the regular expression.
+func (re *Regexp) String() string {
+	return re.expr
+}
+
+// MatchString reports whether s contains a match of the regular expression.
+func (re *Regexp) MatchString(s string) bool {
+	_, _, ok := re.match(s)
+	return ok
+}
+
+// FindStringIndex returns the start and end of the leftmost match in s, or nil
+// if there is none. Like the regexp package, it chooses among the matches
+// starting there the one preferred by the alternations and repetitions.
+func (re *Regexp) FindStringIndex(s string) []int {
+	start, end, ok := re.match(s)
+	if !ok {
+		return nil
+	}
+	return []int{start, end}
+}
+
+// FindString returns the text of the leftmost match in s, or "" if there is
+// none, which cannot be told apart from an empty match.
+func (re *Regexp) FindString(s string) string {
+	start, end, _ := re.match(s)
+	return s[start:end]
+}
@@ -0,0 +1,232 @@
+package regex
+
+import (
+	"errors"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// patterns and texts are matched against each other, by this package and by
+// the regexp package.
+var patterns = []string{
+	``,
+	`a`,
+	`abc`,
+	`a.c`,
+	`a*`,
+	`a+`,
+	`a?`,
+	`a*?`,
+	`a+?`,
+	`a??`,
+	`ab*c`,
+	`ab+c`,
+	`ab?c`,
+	`a|b`,
+	`a|b|c`,
+	`ab|cd`,
+	`a(b|c)d`,
+	`(a|ab)(c|bcd)`,
+	`(a|ab)*c`,
+	`(a*)*`,
+	`(a*)+`,
+	`(a|b)*`,
+	`(a*|b)*`,
+	`(|a)*`,
+	`(|a)+`,
+	`(a|)*?b`,
+	`()`,
+	`a|`,
+	`|a`,
+	`^a`,
+	`a$`,
+	`^abc$`,
+	`^$`,
+	`^`,
+	`$`,
+	`x*$`,
+	`^*`,
+	`(^a|b)+`,
+	`a^b`,
+	`.`,
+	`.*`,
+	`.+`,
+	`a.*c`,
+	`a.*?c`,
+	`[abc]`,
+	`[a-c]+`,
+	`[^abc]+`,
+	`[a-]+`,
+	`[-a]+`,
+	`[]a]+`,
+	`[^]a]+`,
+	`[a-cx-z]+`,
+	`[z-zc-ab]+`,
+	`[\]\-\\]+`,
+	`[^\n]+`,
+	`[\d.]+`,
+	`[\D]+`,
+	`[^\d\s]+`,
+	`\d+`,
+	`\D+`,
+	`\w+`,
+	`\W+`,
+	`\s+`,
+	`\S+`,
+	`\.\*\+\?\(\)\[\]\{\}\|\^\$`,
+	`a\nb`,
+	`\t`,
+	`a\{2\}`,
+	`{`,
+	`}a`,
+	`é+`,
+	`[à-ü]+`,
+	`[^a-z]`,
+	`(\d+)\.(\d*)`,
+	`[0-9]+\.[0-9]*`,
+	`a(b|c)*d`,
+	`(foo|foobar)(bar)?`,
+	`(a+)(b+)?`,
+	`(a+?)(b*)`,
+	`x*y*z*`,
+	`(ab)*ab`,
+	`((a|b)c)*`,
+	`a+b+`,
+	`(?:`,
+}
+
+var texts = []string{
+	"",
+	"a",
+	"b",
+	"aaa",
+	"abc",
+	"abbbc",
+	"ac",
+	"abd",
+	"acd",
+	"abcd",
+	"abcbd",
+	"xabcx",
+	"cab",
+	"aab",
+	"a\nc",
+	"a\nb",
+	"\t",
+	"  tab\tand\nnewline ",
+	"x 3.14 y",
+	"a{2}",
+	"aa",
+	"{}",
+	"]a-\\",
+	"café déjà vu",
+	"foobar",
+	"foobarbar",
+	"abab",
+	"acbcac",
+	"xyz",
+	"zyx",
+	".*+?()[]{}|^$",
+	"hello, world 42",
+}
+
+func TestCorpus(t *testing.T) {
+	for _, pattern := range patterns {
+		want, wantErr := regexp.Compile(pattern)
+		re, err := Compile(pattern)
+		if (err != nil) != (wantErr != nil) {
+			t.Errorf("Compile(%q): got error %v, regexp got %v", pattern, err, wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		for _, text := range texts {
+			if got, want := re.FindStringIndex(text), want.FindStringIndex(text); !reflect.DeepEqual(got, want) {
+				t.Errorf("%q.FindStringIndex(%q) = %v, regexp got %v", pattern, text, got, want)
+			}
+			if got, want := re.MatchString(text), want.MatchString(text); got != want {
+				t.Errorf("%q.MatchString(%q) = %v, regexp got %v", pattern, text, got, want)
+			}
+		}
+	}
+}
+
+// TestErrors checks that syntax errors are returned, and not panicked, with
+// the same messages as the regexp package.
+func TestErrors(t *testing.T) {
+	for _, test := range []struct {
+		pattern string
+		err     Error
+	}{
+		{`*`, Error{"missing argument to repetition operator", "*"}},
+		{`+a`, Error{"missing argument to repetition operator", "+"}},
+		{`a|?`, Error{"missing argument to repetition operator", "?"}},
+		{`(*?)`, Error{"missing argument to repetition operator", "*?"}},
+		{`a**`, Error{"invalid nested repetition operator", "**"}},
+		{`a+?*`, Error{"invalid nested repetition operator", "+?*"}},
+		{`a???`, Error{"invalid nested repetition operator", "???"}},
+		{`(a`, Error{"missing closing )", "(a"}},
+		{`((a)|b`, Error{"missing closing )", "((a)|b"}},
+		{`a)`, Error{"unexpected )", "a)"}},
+		{`())`, Error{"unexpected )", "())"}},
+		{`[a`, Error{"missing closing ]", "[a"}},
+		{`x[]`, Error{"missing closing ]", "[]"}},
+		{`[^`, Error{"missing closing ]", "[^"}},
+		{`[z-a]`, Error{"invalid character class range", "z-a"}},
+		{`[a\`, Error{"trailing backslash at end of expression", ""}},
+		{`a\`, Error{"trailing backslash at end of expression", ""}},
+		{`\q`, Error{"invalid escape sequence", `\q`}},
+		{`[a-\d]`, Error{"invalid escape sequence", `\d`}},
+	} {
+		re, err := Compile(test.pattern)
+		if re != nil || err != test.err {
+			t.Errorf("Compile(%q) = %v, %v, want nil, %v", test.pattern, re, err, test.err)
+		}
+		var syntaxErr Error
+		if !errors.As(err, &syntaxErr) {
+			continue
+		}
+		_, want := regexp.Compile(test.pattern)
+		if want == nil {
+			t.Errorf("regexp.Compile(%q) accepts it", test.pattern)
+		} else if got, want := strings.TrimPrefix(err.Error(), "regex: "), strings.TrimPrefix(want.Error(), "error parsing regexp: "); got != want {
+			t.Errorf("Compile(%q) error %q, regexp says %q", test.pattern, got, want)
+		}
+	}
+}
+
+func TestMustCompile(t *testing.T) {
+	defer func() {
+		if e := recover(); e != (Error{"missing closing )", "(a"}) {
+			t.Errorf("MustCompile panicked with %v", e)
+		}
+	}()
+	MustCompile(`(a`)
+	t.Error("MustCompile did not panic")
+}
+
+func TestFindString(t *testing.T) {
+	re := MustCompile(`[0-9]+\.[0-9]*`)
+	if got := re.FindString("pi is 3.14159."); got != "3.14159" {
+		t.Errorf("got %q, want 3.14159", got)
+	}
+	if got := re.FindString("none"); got != "" {
+		t.Errorf("got %q, want nothing", got)
+	}
+	if got := re.String(); got != `[0-9]+\.[0-9]*` {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+// TestLinearTime matches an expression which takes exponential time with
+// backtracking: (a?){n}a{n} against a{n}.
+func TestLinearTime(t *testing.T) {
+	const n = 100
+	re := MustCompile(strings.Repeat("a?", n) + strings.Repeat("a", n))
+	if !re.MatchString(strings.Repeat("a", n)) {
+		t.Error("no match")
+	}
+}
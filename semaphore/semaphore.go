@@ -0,0 +1,117 @@
+// Package semaphore provides a weighted semaphore, which limits the use of a
+// resource shared by goroutines which each need a different amount of it,
+// such as a memory budget.
+//
+// When every holder needs one unit, a buffered channel is enough: see the
+// moreOnChannels/channelSemaphore section.
+package semaphore
+
+import (
+	"container/list"
+	"context"
+	"sync"
+)
+
+// Weighted is a semaphore of a given size, of which holders acquire any
+// number of units at once. It is safe for concurrent use.
+type Weighted struct {
+	size    int64
+	mu      sync.Mutex
+	cur     int64     // units held
+	waiters list.List // of waiter, in the order they called Acquire
+}
+
+// waiter is a goroutine waiting in Acquire.
+type waiter struct {
+	n     int64
+	ready chan struct{} // closed when the units are acquired
+}
+
+// NewWeighted returns a semaphore of n units.
+func NewWeighted(n int64) *Weighted {
+	return &Weighted{size: n}
+}
+
+// Acquire waits until n units are available and acquires them, or returns
+// ctx.Err() if ctx is done first, in which case nothing is acquired.
+//
+// The waiters are served in order: while the first one waits for its units,
+// those behind it wait too even if their request is smaller. This prevents a
+// stream of small requests from starving a large one.
+func (s *Weighted) Acquire(ctx context.Context, n int64) error {
+	s.mu.Lock()
+	if s.size-s.cur >= n && s.waiters.Len() == 0 {
+		s.cur += n
+		s.mu.Unlock()
+		return nil
+	}
+	if n > s.size {
+		// The request can never be satisfied.
+		s.mu.Unlock()
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	ready := make(chan struct{})
+	elem := s.waiters.PushBack(waiter{n, ready})
+	s.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		select {
+		case <-ready:
+			// The units were acquired as ctx was done: give them back, so
+			// that a failed Acquire never holds any.
+			s.cur -= n
+		default:
+			s.waiters.Remove(elem)
+		}
+		// Removing the first waiter may let the next ones acquire.
+		s.notifyWaiters()
+		return ctx.Err()
+	}
+}
+
+// TryAcquire acquires n units if they are available without waiting, and
+// reports whether it did.
+func (s *Weighted) TryAcquire(n int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.size-s.cur >= n && s.waiters.Len() == 0 {
+		s.cur += n
+		return true
+	}
+	return false
+}
+
+// Release releases n units. It panics if more units are released than held.
+func (s *Weighted) Release(n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cur -= n
+	if s.cur < 0 {
+		panic("semaphore: released more than held")
+	}
+	s.notifyWaiters()
+}
+
+// notifyWaiters lets the waiters acquire their units in order, as long as
+// there are enough.
+func (s *Weighted) notifyWaiters() {
+	for {
+		front := s.waiters.Front()
+		if front == nil {
+			return
+		}
+		w := front.Value.(waiter)
+		if s.size-s.cur < w.n {
+			return
+		}
+		s.cur += w.n
+		s.waiters.Remove(front)
+		close(w.ready)
+	}
+}
@@ -0,0 +1,147 @@
+package semaphore
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// acquireAsync calls Acquire in a goroutine and returns its result channel.
+func acquireAsync(ctx context.Context, s *Weighted, n int64) <-chan error {
+	errc := make(chan error, 1)
+	go func() { errc <- s.Acquire(ctx, n) }()
+	return errc
+}
+
+// blocked fails the test if Acquire returned.
+func blocked(t *testing.T, errc <-chan error) {
+	t.Helper()
+	select {
+	case err := <-errc:
+		t.Fatalf("Acquire returned %v, want it to wait", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestAcquireRelease(t *testing.T) {
+	ctx := context.Background()
+	s := NewWeighted(10)
+	if err := s.Acquire(ctx, 7); err != nil {
+		t.Fatal(err)
+	}
+	if s.TryAcquire(4) {
+		t.Error("TryAcquire(4) succeeded with 3 units left")
+	}
+	if !s.TryAcquire(3) {
+		t.Error("TryAcquire(3) failed with 3 units left")
+	}
+	errc := acquireAsync(ctx, s, 5)
+	blocked(t, errc)
+	s.Release(7)
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	s.Release(8)
+	if !s.TryAcquire(10) {
+		t.Error("all units were not released")
+	}
+}
+
+// TestFIFO checks that a large request is not overtaken by smaller ones.
+func TestFIFO(t *testing.T) {
+	ctx := context.Background()
+	s := NewWeighted(10)
+	s.Acquire(ctx, 5)
+	large := acquireAsync(ctx, s, 10)
+	blocked(t, large)
+	if s.TryAcquire(1) {
+		t.Error("TryAcquire(1) overtook the waiting Acquire(10)")
+	}
+	small := acquireAsync(ctx, s, 1)
+	blocked(t, small)
+	s.Release(5)
+	if err := <-large; err != nil {
+		t.Fatal(err)
+	}
+	blocked(t, small)
+	s.Release(10)
+	if err := <-small; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	s := NewWeighted(10)
+	s.Acquire(context.Background(), 5)
+	ctx, cancel := context.WithCancel(context.Background())
+	large := acquireAsync(ctx, s, 10)
+	blocked(t, large)
+	small := acquireAsync(context.Background(), s, 2)
+	blocked(t, small)
+	// Cancelling the first waiter lets the one behind it acquire.
+	cancel()
+	if err := <-large; err != context.Canceled {
+		t.Errorf("cancelled Acquire returned %v", err)
+	}
+	if err := <-small; err != nil {
+		t.Fatal(err)
+	}
+	if !s.TryAcquire(3) || s.TryAcquire(1) {
+		t.Error("the cancelled Acquire holds units")
+	}
+}
+
+func TestTooLarge(t *testing.T) {
+	s := NewWeighted(10)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := s.Acquire(ctx, 11); err != context.DeadlineExceeded {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if !s.TryAcquire(10) {
+		t.Error("the failed Acquire holds units")
+	}
+}
+
+func TestReleaseTooMuch(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Release did not panic")
+		}
+	}()
+	s := NewWeighted(1)
+	s.Release(1)
+}
+
+func TestConcurrent(t *testing.T) {
+	const size = 10
+	s := NewWeighted(size)
+	var held, peak atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(n int64) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Duration(n)*time.Millisecond)
+			defer cancel()
+			if s.Acquire(ctx, n) != nil {
+				return
+			}
+			h := held.Add(n)
+			for p := peak.Load(); h > p && !peak.CompareAndSwap(p, h); p = peak.Load() {
+			}
+			time.Sleep(time.Millisecond)
+			held.Add(-n)
+			s.Release(n)
+		}(int64(i%size + 1))
+	}
+	wg.Wait()
+	if p := peak.Load(); p > size {
+		t.Errorf("%d units held at once, more than %d", p, size)
+	}
+	if !s.TryAcquire(size) {
+		t.Error("units are still held")
+	}
+}
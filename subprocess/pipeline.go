@@ -0,0 +1,198 @@
+package subprocess
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Stage is a stage of a pipeline: a command, or a Go function run in its
+// place.
+type Stage struct {
+	// Cmd is the command. Its Stdin is only used by the first stage and its
+	// Stdout by the last one: the others are connected by pipes.
+	Cmd Cmd
+	// Func, if set, runs in a goroutine instead of the command, which then
+	// only names it in errors. It reads the output of the previous stage from
+	// stdin and writes the input of the next one to stdout, and must return
+	// when ctx is done: reading stdin then fails with the error of ctx, even
+	// if a read was waiting. It fails if it returns an error.
+	Func func(ctx context.Context, stdin io.Reader, stdout io.Writer) error
+}
+
+// Command returns a stage which runs a command.
+func Command(name string, args ...string) Stage {
+	return Stage{Cmd: Cmd{Name: name, Args: args}}
+}
+
+// Func returns a stage which runs fn, named name.
+func Func(name string, fn func(ctx context.Context, stdin io.Reader, stdout io.Writer) error) Stage {
+	return Stage{Cmd: Cmd{Name: name}, Func: fn}
+}
+
+// RunPipeline runs stages connected like "a | b | c" in a shell, the standard
+// output of each stage being the standard input of the next, and waits for
+// all of them to end. Two commands are connected by an OS pipe, which they
+// read and write directly, while two functions use an io.Pipe.
+//
+// When a stage fails, the others are cancelled: the commands are killed, and
+// the context of the functions is done. RunPipeline then returns the error of
+// the stage which failed first, which is an *ExitError with its exit status
+// for a command. Like in a shell, a stage which ends because the next one
+// stopped reading, such as "yes" in "yes | head -1", does not fail.
+//
+// The results are those of each stage, in order. A Func stage has the exit
+// code 0, or 1 if it failed, and captures no standard error.
+func RunPipeline(ctx context.Context, stages ...Stage) ([]Result, error) {
+	results := make([]Result, len(stages))
+	if len(stages) == 0 {
+		return results, nil
+	}
+	readers := make([]io.ReadCloser, len(stages))  // the stdin of each stage but the first
+	writers := make([]io.WriteCloser, len(stages)) // the stdout of each stage but the last
+	for i := range stages[:len(stages)-1] {
+		r, w, err := connect(stages[i], stages[i+1])
+		if err != nil {
+			closeAll(readers[1 : i+1])
+			closeAll(writers[:i])
+			return nil, fmt.Errorf("subprocess: %w", err)
+		}
+		readers[i+1], writers[i] = r, w
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var mu sync.Mutex
+	var first error
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if first == nil {
+			first = err
+			cancel()
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i, s := range stages {
+		c := s.Cmd
+		var ends []io.Closer // the pipes of the stage, which it closes when done
+		if r := readers[i]; r != nil {
+			c.Stdin = r
+			ends = append(ends, r)
+		}
+		if w := writers[i]; w != nil {
+			c.Stdout, c.StdoutPrefix = w, ""
+			ends = append(ends, w)
+		}
+		wg.Add(1)
+		if s.Func != nil {
+			go func() {
+				defer wg.Done()
+				res, err := runFunc(ctx, c, s.Func)
+				closeAll(ends)
+				results[i] = res
+				if err != nil && !brokenPipe(err) {
+					fail(err)
+				}
+			}()
+			continue
+		}
+		p, err := start(ctx, c)
+		// The command has its own copies of the pipes.
+		closeAll(ends)
+		if err != nil {
+			wg.Done()
+			results[i] = Result{ExitCode: -1}
+			fail(err)
+			continue
+		}
+		go func() {
+			defer wg.Done()
+			res, err := p.wait(ctx)
+			results[i] = res
+			if err != nil && !brokenPipe(err) {
+				fail(err)
+			}
+		}()
+	}
+	wg.Wait()
+	return results, first
+}
+
+// connect returns the pipe from a stage to the next one: an OS pipe if either
+// is a command, or an io.Pipe between two functions.
+func connect(from, to Stage) (io.ReadCloser, io.WriteCloser, error) {
+	if from.Func != nil && to.Func != nil {
+		r, w := io.Pipe()
+		return r, w, nil
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	return r, w, nil
+}
+
+// closeAll closes the pipes of a stage.
+func closeAll[T io.Closer](closers []T) {
+	for _, c := range closers {
+		c.Close()
+	}
+}
+
+// runFunc runs the function of a Func stage, with the input and output of c.
+func runFunc(ctx context.Context, c Cmd, fn func(ctx context.Context, stdin io.Reader, stdout io.Writer) error) (Result, error) {
+	var stdin io.Reader = bytes.NewReader(nil)
+	if c.Stdin != nil {
+		stdin = &contextReader{ctx: ctx, r: c.Stdin}
+	}
+	var stdout bytes.Buffer
+	w := output(c.Stdout, c.StdoutPrefix, &stdout, new(sync.Mutex))
+	start := time.Now()
+	err := fn(ctx, stdin, w)
+	flushOutput(w)
+	res := Result{Duration: time.Since(start), Stdout: stdout.Bytes()}
+	if err != nil {
+		res.ExitCode = 1
+		err = contextError(ctx, fmt.Errorf("subprocess: %s: %w", c.Name, err))
+	}
+	return res, err
+}
+
+// contextReader reads r until ctx is done, and then fails with the error of
+// ctx. Its reads run in a goroutine, so that one which waits for input, such
+// as that of a terminal or of a pipe, can be given up on: it is left to end
+// in the background, and what it reads is dropped.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+	buf []byte // read into by the goroutine, which may outlive a Read
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if len(r.buf) < len(p) {
+		r.buf = make([]byte, len(p))
+	}
+	buf := r.buf[:len(p)]
+	var n int
+	var err error
+	done := make(chan struct{})
+	go func() {
+		n, err = r.r.Read(buf)
+		close(done)
+	}()
+	select {
+	case <-done:
+		return copy(p, buf[:n]), err
+	case <-r.ctx.Done():
+		return 0, r.ctx.Err()
+	}
+}
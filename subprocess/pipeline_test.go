@@ -0,0 +1,173 @@
+package subprocess
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+// grep is a Func stage which prints the lines containing s.
+func grep(s string) Stage {
+	return Func("grep "+s, func(ctx context.Context, stdin io.Reader, stdout io.Writer) error {
+		scanner := bufio.NewScanner(stdin)
+		for scanner.Scan() {
+			if strings.Contains(scanner.Text(), s) {
+				if _, err := fmt.Fprintln(stdout, scanner.Text()); err != nil {
+					return err
+				}
+			}
+		}
+		return scanner.Err()
+	})
+}
+
+func TestPipeline(t *testing.T) {
+	needSh(t)
+	first := Command("sh", "-c", "echo go; echo rust; echo gopher; echo golang >&2")
+	first.Cmd.Stdin = strings.NewReader("unused")
+	results, err := RunPipeline(context.Background(), first, Command("grep", "go"), Command("wc", "-l"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 || strings.TrimSpace(string(results[2].Stdout)) != "2" {
+		t.Fatalf("got results %+v", results)
+	}
+	if string(results[0].Stderr) != "golang\n" || results[0].Stdout != nil {
+		t.Errorf("got first stage result %+v", results[0])
+	}
+}
+
+// TestFuncStages checks Func stages between commands, connected by OS pipes,
+// and between themselves, connected by an io.Pipe.
+func TestFuncStages(t *testing.T) {
+	needSh(t)
+	var out strings.Builder
+	last := Command("tr", "a-z", "A-Z")
+	last.Cmd.Stdout = &out
+	results, err := RunPipeline(context.Background(),
+		Command("sh", "-c", "echo go; echo rust; echo gopher"),
+		grep("go"),
+		grep("ph"),
+		last)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != "GOPHER\n" {
+		t.Errorf("got %q", got)
+	}
+	if results[1].ExitCode != 0 || results[3].Stdout != nil {
+		t.Errorf("got results %+v", results)
+	}
+
+	// A Func stage can also be first or last.
+	first := grep("a")
+	first.Cmd.Stdin = strings.NewReader("a\nb\nab\n")
+	results, err = RunPipeline(context.Background(), first, grep("b"))
+	if err != nil || string(results[1].Stdout) != "ab\n" {
+		t.Errorf("got %+v, %v", results, err)
+	}
+}
+
+// TestPipelineFailure checks that the first stage to fail ends the others,
+// which would otherwise run for 10 seconds, and gives its exit status.
+func TestPipelineFailure(t *testing.T) {
+	needSh(t)
+	start := time.Now()
+	results, err := RunPipeline(context.Background(),
+		Command("sleep", "10"),
+		Command("sh", "-c", "cat; exit 3"),
+		Command("sh", "-c", "exit 4"),
+		Func("wait", func(ctx context.Context, stdin io.Reader, stdout io.Writer) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}))
+	if elapsed := time.Since(start); elapsed > killDelay/2 {
+		t.Errorf("RunPipeline returned after %v", elapsed)
+	}
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || exitErr.Result.ExitCode != 4 {
+		t.Fatalf("got %v, want the exit status 4", err)
+	}
+	if errors.Is(err, context.Canceled) {
+		t.Errorf("%v is the error of a cancelled stage", err)
+	}
+	if results[0].ExitCode != -1 || results[2].ExitCode != 4 || results[3].ExitCode != 1 {
+		t.Errorf("got results %+v", results)
+	}
+
+	// A Func stage fails with its error.
+	errFunc := errors.New("func failed")
+	_, err = RunPipeline(context.Background(),
+		Command("sleep", "10"),
+		Func("failing", func(ctx context.Context, stdin io.Reader, stdout io.Writer) error {
+			return errFunc
+		}))
+	if !errors.Is(err, errFunc) || err.Error() != "subprocess: failing: func failed" {
+		t.Errorf("got %v", err)
+	}
+}
+
+// TestFuncStdinCancel checks that a Func stage waiting for its input returns
+// when the context is done.
+func TestFuncStdinCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	stdin, w := io.Pipe() // nothing is ever written
+	defer w.Close()
+	copyStage := Func("copy", func(ctx context.Context, stdin io.Reader, stdout io.Writer) error {
+		_, err := io.Copy(stdout, stdin)
+		return err
+	})
+	copyStage.Cmd.Stdin = stdin
+	start := time.Now()
+	_, err := RunPipeline(ctx, copyStage)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("RunPipeline returned after %v", elapsed)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want a deadline exceeded error", err)
+	}
+}
+
+// TestBrokenPipe checks that a stage which ends because the next one stopped
+// reading does not fail, be it a command or a function.
+func TestBrokenPipe(t *testing.T) {
+	needSh(t)
+	results, err := RunPipeline(context.Background(), Command("yes"), Command("head", "-n", "1"))
+	if err != nil || string(results[1].Stdout) != "y\n" {
+		t.Errorf("got %+v, %v", results, err)
+	}
+	yes := Func("yes", func(ctx context.Context, stdin io.Reader, stdout io.Writer) error {
+		for {
+			if _, err := io.WriteString(stdout, "y\n"); err != nil {
+				return err
+			}
+		}
+	})
+	// A function which stops reading after the first line.
+	head := Func("head", func(ctx context.Context, stdin io.Reader, stdout io.Writer) error {
+		line, err := bufio.NewReader(stdin).ReadString('\n')
+		io.WriteString(stdout, line)
+		return err
+	})
+	for _, next := range []Stage{Command("head", "-n", "1"), head} {
+		results, err := RunPipeline(context.Background(), yes, next)
+		if err != nil || string(results[1].Stdout) != "y\n" {
+			t.Errorf("yes | %s: got %+v, %v", next.Cmd.Name, results, err)
+		}
+	}
+}
+
+func TestPipelineNotFound(t *testing.T) {
+	needSh(t)
+	results, err := RunPipeline(context.Background(), Command("sleep", "10"), Command("no-such-command"))
+	if !errors.Is(err, exec.ErrNotFound) || results[1].ExitCode != -1 {
+		t.Errorf("got %+v, %v", results, err)
+	}
+}
@@ -0,0 +1,29 @@
+//go:build !unix
+
+package subprocess
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// setProcessGroup does nothing where there are no process groups.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup kills only the command, as its children cannot be found.
+func killProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
+
+// signal returns nil: only Unix reports the signal which killed a process.
+func signal(state *os.ProcessState) os.Signal {
+	return nil
+}
+
+// brokenPipe reports whether err is the failure of a Func stage which wrote
+// to an io.Pipe nobody reads anymore. The broken OS pipes are not detected.
+func brokenPipe(err error) bool {
+	return errors.Is(err, io.ErrClosedPipe)
+}
@@ -0,0 +1,42 @@
+//go:build unix
+
+package subprocess
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup makes the command the leader of a new process group, which
+// the processes it starts join.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killProcessGroup kills the process group of the command: a negative pid
+// designates a group.
+func killProcessGroup(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
+
+// signal returns the signal which killed a process, or nil.
+func signal(state *os.ProcessState) os.Signal {
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return status.Signal()
+	}
+	return nil
+}
+
+// brokenPipe reports whether err is the failure of a stage which wrote to a
+// pipe nobody reads anymore: a command killed by SIGPIPE, or a write which
+// failed with EPIPE.
+func brokenPipe(err error) bool {
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.Result.Signal == syscall.SIGPIPE
+	}
+	return errors.Is(err, syscall.EPIPE) || errors.Is(err, io.ErrClosedPipe)
+}
@@ -0,0 +1,242 @@
+// Package subprocess runs external commands, extending what exec.Cmd does for
+// the runCommand helper of the communicationInGo section:
+//
+//	res, err := subprocess.Run(ctx, subprocess.Cmd{
+//		Name:         "make",
+//		Args:         []string{"test"},
+//		Dir:          "src",
+//		Env:          []string{"GOFLAGS=-v"},
+//		Stdout:       os.Stdout,
+//		StdoutPrefix: "make| ",
+//	})
+//	var exitErr *subprocess.ExitError
+//	if errors.As(err, &exitErr) {
+//		log.Printf("make failed with status %d: %s", res.ExitCode, res.Stderr)
+//	}
+//
+// When the context is done, the command is killed along with every process it
+// started, which on Unix share its process group. Otherwise a child such as
+// "sleep 60 &" would keep running, and keep the output pipes open. Being out
+// of the process group of the terminal, the commands do not get the SIGINT of
+// Ctrl-C: a program should cancel their context when it gets the signal, for
+// instance with signal.NotifyContext.
+//
+// RunPipeline connects commands, and Go functions, like a shell pipeline:
+//
+//	results, err := subprocess.RunPipeline(ctx,
+//		subprocess.Command("ls", "-l"),
+//		subprocess.Func("grep go", func(ctx context.Context, r io.Reader, w io.Writer) error {
+//			s := bufio.NewScanner(r)
+//			for s.Scan() {
+//				if strings.Contains(s.Text(), "go") {
+//					fmt.Fprintln(w, s.Text())
+//				}
+//			}
+//			return s.Err()
+//		}),
+//		subprocess.Command("wc", "-l"),
+//	)
+//	fmt.Printf("%s", results[2].Stdout)
+package subprocess
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cmd describes a command to run.
+type Cmd struct {
+	Name string   // the program, looked up in $PATH unless it contains a slash
+	Args []string // the arguments, without the program
+	Dir  string   // the working directory, by default the current one
+	// Env holds "KEY=value" variables which are added to, or override, the
+	// environment of the current process.
+	Env   []string
+	Stdin io.Reader // by default, the command reads nothing
+
+	// Stdout and Stderr receive the output of the command line by line as it
+	// is printed, each line preceded by the prefix. By default, the output
+	// is only captured in the Result. Both can be the same writer, as the
+	// lines are written whole, one at a time. A file without a prefix, such
+	// as os.Stdout, is instead written directly by the command.
+	Stdout, Stderr             io.Writer
+	StdoutPrefix, StderrPrefix string
+}
+
+// String returns the command line.
+func (c Cmd) String() string {
+	return strings.Join(append([]string{c.Name}, c.Args...), " ")
+}
+
+// Result is the outcome of a command.
+type Result struct {
+	ExitCode int           // -1 if the command did not start or was killed by a signal
+	Signal   os.Signal     // the signal which killed the command, on Unix
+	Duration time.Duration // from the start to the end of the command
+	// Stdout and Stderr are the output of the command, unless it was sent to
+	// Cmd.Stdout or Cmd.Stderr instead.
+	Stdout, Stderr []byte
+}
+
+// ExitError reports a command which exited with a non-zero status or was
+// killed by a signal.
+type ExitError struct {
+	Cmd    Cmd
+	Result Result
+	Err    *exec.ExitError
+}
+
+func (e *ExitError) Error() string {
+	if e.Result.Signal != nil {
+		return fmt.Sprintf("subprocess: %s was killed by signal: %v", e.Cmd, e.Result.Signal)
+	}
+	return fmt.Sprintf("subprocess: %s exited with status %d", e.Cmd, e.Result.ExitCode)
+}
+
+// Unwrap returns the underlying *exec.ExitError.
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// killDelay bounds the wait for the output pipes of a killed command, in case
+// a process which left its process group holds them open.
+const killDelay = time.Second
+
+// Run runs a command and waits for it to end, or kills it when ctx is done.
+// It returns an error if the command could not start, an *ExitError if it
+// failed, and also the error of ctx if it was killed because of it.
+func Run(ctx context.Context, c Cmd) (Result, error) {
+	p, err := start(ctx, c)
+	if err != nil {
+		return Result{ExitCode: -1}, err
+	}
+	return p.wait(ctx)
+}
+
+// process is a started command.
+type process struct {
+	c              Cmd
+	cmd            *exec.Cmd
+	stdout, stderr bytes.Buffer
+	start          time.Time
+}
+
+// start starts a command, which is killed when ctx is done.
+func start(ctx context.Context, c Cmd) (*process, error) {
+	p := &process{c: c}
+	cmd := exec.CommandContext(ctx, c.Name, c.Args...)
+	cmd.Dir = c.Dir
+	if c.Env != nil {
+		// When a variable is repeated, exec uses its last value.
+		cmd.Env = append(os.Environ(), c.Env...)
+	}
+	cmd.Stdin = c.Stdin
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error { return killProcessGroup(cmd) }
+	cmd.WaitDelay = killDelay
+
+	var mu sync.Mutex // shared by the line writers
+	cmd.Stdout = output(c.Stdout, c.StdoutPrefix, &p.stdout, &mu)
+	cmd.Stderr = output(c.Stderr, c.StderrPrefix, &p.stderr, &mu)
+	p.cmd = cmd
+
+	p.start = time.Now()
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("subprocess: %w", err)
+	}
+	return p, nil
+}
+
+// output returns the writer of an output of a command: buf if w is nil, or
+// else a lineWriter to w. A file without a prefix is given to the command as
+// is, which saves a copy, as for the pipes between the stages of a pipeline.
+func output(w io.Writer, prefix string, buf *bytes.Buffer, mu *sync.Mutex) io.Writer {
+	if w == nil {
+		return buf
+	}
+	if _, ok := w.(*os.File); ok && prefix == "" {
+		return w
+	}
+	return &lineWriter{w: w, prefix: prefix, mu: mu}
+}
+
+// flushOutput writes the incomplete last line of an output.
+func flushOutput(w io.Writer) {
+	if lw, ok := w.(*lineWriter); ok {
+		lw.flush()
+	}
+}
+
+// wait waits for the command to end and returns its result.
+func (p *process) wait(ctx context.Context) (Result, error) {
+	err := p.cmd.Wait()
+	res := Result{Duration: time.Since(p.start)}
+	flushOutput(p.cmd.Stdout)
+	flushOutput(p.cmd.Stderr)
+	res.Stdout, res.Stderr = p.stdout.Bytes(), p.stderr.Bytes()
+	res.ExitCode = p.cmd.ProcessState.ExitCode()
+	res.Signal = signal(p.cmd.ProcessState)
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		err = &ExitError{p.c, res, exitErr}
+	} else if err != nil {
+		err = fmt.Errorf("subprocess: %s: %w", p.c, err) // such as exec.ErrWaitDelay
+	}
+	return res, contextError(ctx, err)
+}
+
+// contextError adds the error of ctx to err, if ctx is done: the failure is
+// then likely caused by the cancellation.
+func contextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil && err != nil {
+		return fmt.Errorf("%w: %w", ctxErr, err)
+	}
+	return err
+}
+
+// lineWriter writes whole lines to w, each preceded by prefix. The lineWriters
+// of a command share a mutex, so that they can write to the same w.
+type lineWriter struct {
+	w      io.Writer
+	prefix string
+	mu     *sync.Mutex
+	line   []byte // the incomplete last line
+}
+
+func (lw *lineWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			lw.line = append(lw.line, p...)
+			break
+		}
+		lw.line = append(lw.line, p[:i+1]...)
+		p = p[i+1:]
+		if err := lw.flush(); err != nil {
+			return n - len(p), err
+		}
+	}
+	return n, nil
+}
+
+// flush writes the current line, even if it is incomplete.
+func (lw *lineWriter) flush() error {
+	if len(lw.line) == 0 {
+		return nil
+	}
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	_, err := lw.w.Write(append([]byte(lw.prefix), lw.line...))
+	lw.line = lw.line[:0]
+	return err
+}
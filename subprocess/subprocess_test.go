@@ -0,0 +1,153 @@
+package subprocess
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os/exec"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func needSh(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh to run")
+	}
+}
+
+func sh(script string) Cmd {
+	return Cmd{Name: "sh", Args: []string{"-c", script}}
+}
+
+func TestRun(t *testing.T) {
+	needSh(t)
+	res, err := Run(context.Background(), sh("echo out; echo err >&2"))
+	if err != nil || res.ExitCode != 0 || res.Signal != nil {
+		t.Fatalf("got %+v, %v", res, err)
+	}
+	if string(res.Stdout) != "out\n" || string(res.Stderr) != "err\n" {
+		t.Errorf("got stdout %q, stderr %q", res.Stdout, res.Stderr)
+	}
+	if res.Duration <= 0 {
+		t.Errorf("got duration %v", res.Duration)
+	}
+}
+
+func TestExitStatus(t *testing.T) {
+	needSh(t)
+	c := sh("echo failing >&2; exit 3")
+	res, err := Run(context.Background(), c)
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || res.ExitCode != 3 || exitErr.Result.ExitCode != 3 {
+		t.Fatalf("got %+v, %v, want an *ExitError with status 3", res, err)
+	}
+	if string(exitErr.Result.Stderr) != "failing\n" {
+		t.Errorf("got stderr %q", exitErr.Result.Stderr)
+	}
+	var execErr *exec.ExitError
+	if !errors.As(err, &execErr) {
+		t.Errorf("%v does not wrap an *exec.ExitError", err)
+	}
+	if want := "subprocess: sh -c echo failing >&2; exit 3 exited with status 3"; err.Error() != want {
+		t.Errorf("got message %q, want %q", err, want)
+	}
+}
+
+func TestStdinEnvDir(t *testing.T) {
+	needSh(t)
+	c := sh(`tr a-z A-Z; echo "$GREETING $PWD"`)
+	c.Stdin = strings.NewReader("hello\n")
+	c.Env = []string{"GREETING=hi", "GREETING=bye"} // the last one wins
+	c.Dir = "/"
+	res, err := Run(context.Background(), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(res.Stdout), "HELLO\nbye /\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestPrefixes(t *testing.T) {
+	needSh(t)
+	var out bytes.Buffer
+	c := sh("printf 'a\\nb'; echo c >&2; printf 'd\\ne'")
+	c.Stdout, c.StdoutPrefix = &out, "out| "
+	c.Stderr, c.StderrPrefix = &out, "err| "
+	res, err := Run(context.Background(), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The order of the lines of stdout and stderr, which are read from
+	// different pipes, depends on the scheduler.
+	lines := strings.SplitAfter(out.String(), "\n")
+	sort.Strings(lines)
+	if got, want := strings.Join(lines, ""), "err| c\nout| a\nout| bd\nout| e"; got != want {
+		t.Errorf("got %q, want the lines of %q", out.String(), want)
+	}
+	if res.Stdout != nil || res.Stderr != nil {
+		t.Errorf("captured %q and %q, which were streamed", res.Stdout, res.Stderr)
+	}
+}
+
+// lineRecorder records the lines written to it, with the time they came.
+type lineRecorder struct {
+	lines []string
+	times []time.Time
+}
+
+func (r *lineRecorder) Write(p []byte) (int, error) {
+	r.lines = append(r.lines, string(p))
+	r.times = append(r.times, time.Now())
+	return len(p), nil
+}
+
+// TestStreaming checks that the lines are written as the command prints them,
+// not when it ends.
+func TestStreaming(t *testing.T) {
+	needSh(t)
+	var r lineRecorder
+	c := sh("echo first; sleep 0.3; echo second")
+	c.Stdout = &r
+	start := time.Now()
+	if _, err := Run(context.Background(), c); err != nil {
+		t.Fatal(err)
+	}
+	end := time.Now()
+	if len(r.lines) != 2 || r.lines[0] != "first\n" {
+		t.Fatalf("got lines %q", r.lines)
+	}
+	if r.times[0].Sub(start) >= end.Sub(start)-100*time.Millisecond {
+		t.Errorf("the first line came after %v, at the end", r.times[0].Sub(start))
+	}
+}
+
+// TestTimeout checks that the children of a command are killed with it, so
+// that Run does not wait for them to close the output pipes.
+func TestTimeout(t *testing.T) {
+	needSh(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	res, err := Run(ctx, sh("sleep 10 & sleep 10"))
+	if elapsed := time.Since(start); elapsed > killDelay/2 {
+		t.Errorf("Run returned after %v", elapsed)
+	}
+	var exitErr *ExitError
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.As(err, &exitErr) {
+		t.Fatalf("got %v, want a deadline exceeded *ExitError", err)
+	}
+	if res.ExitCode != -1 || res.Signal != syscall.SIGKILL {
+		t.Errorf("got exit code %d and signal %v", res.ExitCode, res.Signal)
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	res, err := Run(context.Background(), Cmd{Name: "no-such-command"})
+	if !errors.Is(err, exec.ErrNotFound) || res.ExitCode != -1 {
+		t.Errorf("got %+v, %v", res, err)
+	}
+}
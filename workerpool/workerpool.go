@@ -0,0 +1,272 @@
+// Package workerpool runs jobs concurrently on a fixed number of goroutines.
+//
+// It generalizes the server of the moreOnChannels section, where requests
+// sent on a queue are served by one goroutine per CPU:
+//
+//	pool := workerpool.New(ctx, runtime.GOMAXPROCS(0), 100, resize)
+//	go func() {
+//		for _, img := range images {
+//			if err := pool.Submit(ctx, img); err != nil {
+//				break
+//			}
+//		}
+//		pool.Close()
+//	}()
+//	for res := range pool.Results() {
+//		if res.Err != nil {
+//			log.Printf("resizing %v: %v", res.Job, res.Err)
+//		}
+//	}
+//
+// The results must be received for the workers to make progress, unless the
+// context of the pool is cancelled.
+//
+// The workers and the operations on the channels of a pool can be observed
+// with the hooks of a Trace, given to New with WithTrace.
+package workerpool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+)
+
+// ErrClosed is returned by Submit after Close has been called.
+var ErrClosed = errors.New("workerpool: pool is closed")
+
+// Result is the outcome of a job.
+type Result[J, R any] struct {
+	Job   J
+	Value R
+	Err   error // returned by the job function, or a *PanicError
+}
+
+// PanicError is the error of a job whose function panicked.
+type PanicError struct {
+	Value interface{} // passed to panic
+	Stack []byte      // of the goroutine which panicked
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("workerpool: job panicked: %v", e.Value)
+}
+
+// Pool runs the jobs submitted to it with a function, on a fixed number of
+// worker goroutines, and delivers their results on a channel.
+type Pool[J, R any] struct {
+	fn      func(context.Context, J) (R, error)
+	ctx     context.Context
+	jobs    chan J
+	results chan Result[J, R]
+	workers sync.WaitGroup
+
+	done      chan struct{} // closed by Close, to release the blocked calls to Submit
+	closeOnce sync.Once
+	mu        sync.Mutex // guards closed, so that no call to Submit starts after Close
+	closed    bool
+	submits   sync.WaitGroup // calls to Submit in progress, which may send on jobs
+
+	trace *Trace // never nil
+}
+
+// Trace is a set of hooks called by a pool, in the manner of
+// net/http/httptrace, which let a program observe it: for instance, draw
+// which goroutine unblocked which. Any of them may be nil.
+//
+// A send on, or a receive from, a channel of the pool is surrounded by two
+// calls from the goroutine doing it: Send or Recv before, and the function it
+// returns, if not nil, right after. The latter is given the value sent or
+// received, which is the job for the queue and the value of the result for
+// the results, and whether the operation happened at all: it does not if the
+// pool is closed or its context is done first. Since the goroutine does
+// nothing else in between, hooks which make the goroutines take turns on a
+// channel see their operations in the order they happen, at the cost of
+// serializing them.
+type Trace struct {
+	// Channel is called by New with the queue of jobs, named "queue", and
+	// the channel returned by Results, named "results". The other hooks are
+	// given these values.
+	Channel func(ch any, name string)
+	// WorkerStart is called by each worker goroutine when it starts.
+	WorkerStart func()
+	Send        func(ch any) (done func(v any, ok bool))
+	Recv        func(ch any) (done func(v any, ok bool))
+	// Close is called after the queue is closed by Close.
+	Close func(ch any)
+}
+
+type traceKey struct{}
+
+// WithTrace returns a copy of ctx carrying trace, for New.
+func WithTrace(ctx context.Context, trace *Trace) context.Context {
+	return context.WithValue(ctx, traceKey{}, trace)
+}
+
+// contextTrace returns the Trace carried by ctx, or one without hooks.
+func contextTrace(ctx context.Context) *Trace {
+	if trace, _ := ctx.Value(traceKey{}).(*Trace); trace != nil {
+		return trace
+	}
+	return &Trace{}
+}
+
+// traceOp calls the hook of an operation on ch, if any, and returns the
+// function to call once the operation is done.
+func traceOp(hook func(ch any) func(v any, ok bool), ch any) func(v any, ok bool) {
+	if hook != nil {
+		if done := hook(ch); done != nil {
+			return done
+		}
+	}
+	return func(any, bool) {}
+}
+
+// New starts a pool of workers goroutines calling fn for each job. Up to
+// queueSize jobs can wait for a worker before Submit blocks.
+//
+// When ctx is cancelled, the workers stop after their current job: jobs still
+// in the queue are dropped, as are results which are not received, and the
+// results channel is closed. If ctx carries a Trace, the pool calls its hooks.
+func New[J, R any](ctx context.Context, workers, queueSize int, fn func(context.Context, J) (R, error)) *Pool[J, R] {
+	if workers < 1 {
+		workers = 1
+	}
+	p := &Pool[J, R]{
+		fn:      fn,
+		ctx:     ctx,
+		jobs:    make(chan J, queueSize),
+		results: make(chan Result[J, R]),
+		done:    make(chan struct{}),
+		trace:   contextTrace(ctx),
+	}
+	if p.trace.Channel != nil {
+		p.trace.Channel(p.queue(), "queue")
+		p.trace.Channel(p.Results(), "results")
+	}
+	p.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	go func() {
+		p.workers.Wait()
+		close(p.results)
+	}()
+	return p
+}
+
+// Submit queues a job, waiting while the queue is full. It fails if ctx or
+// the context of the pool is done, or if the pool is closed, including while
+// it waits.
+func (p *Pool[J, R]) Submit(ctx context.Context, job J) (err error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ErrClosed
+	}
+	p.submits.Add(1)
+	p.mu.Unlock()
+	defer p.submits.Done()
+
+	sent := traceOp(p.trace.Send, p.queue())
+	select {
+	case p.jobs <- job:
+	case <-p.done:
+		err = ErrClosed
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-p.ctx.Done():
+		err = p.ctx.Err()
+	}
+	sent(job, err == nil)
+	return err
+}
+
+// queue returns the queue of jobs as the hooks of a Trace see it.
+func (p *Pool[J, R]) queue() any {
+	return (chan<- J)(p.jobs)
+}
+
+// Results returns the channel on which the result of every job is sent, in
+// the order they complete. It is closed once the pool is closed and all the
+// jobs have completed.
+func (p *Pool[J, R]) Results() <-chan Result[J, R] {
+	return p.results
+}
+
+// Close stops accepting jobs: the calls to Submit waiting for room in the
+// queue fail with ErrClosed. The jobs already submitted still run; the results
+// channel is closed after the last one. Close may be called more than once.
+func (p *Pool[J, R]) Close() {
+	p.closeOnce.Do(func() {
+		p.mu.Lock()
+		p.closed = true
+		p.mu.Unlock()
+		close(p.done)
+		// No one sends on jobs once the calls to Submit have returned.
+		p.submits.Wait()
+		close(p.jobs)
+		if p.trace.Close != nil {
+			p.trace.Close(p.queue())
+		}
+	})
+}
+
+// Wait closes the pool and waits until all its workers have stopped, which
+// requires the results to be received or the context of the pool to be
+// cancelled.
+func (p *Pool[J, R]) Wait() {
+	p.Close()
+	p.workers.Wait()
+}
+
+// work runs jobs until the queue is closed and empty or ctx is done.
+func (p *Pool[J, R]) work() {
+	defer p.workers.Done()
+	if p.trace.WorkerStart != nil {
+		p.trace.WorkerStart()
+	}
+	for {
+		job, ok := p.next()
+		if !ok || !p.send(p.run(job)) {
+			return
+		}
+	}
+}
+
+// next receives the next job. It returns false if the queue is closed and
+// empty or ctx is done.
+func (p *Pool[J, R]) next() (job J, ok bool) {
+	received := traceOp(p.trace.Recv, p.queue())
+	select {
+	case job, ok = <-p.jobs:
+	case <-p.ctx.Done():
+	}
+	received(job, ok)
+	return job, ok
+}
+
+// send sends a result. It returns false if ctx is done first.
+func (p *Pool[J, R]) send(res Result[J, R]) (ok bool) {
+	sent := traceOp(p.trace.Send, p.Results())
+	select {
+	case p.results <- res:
+		ok = true
+	case <-p.ctx.Done():
+	}
+	sent(res.Value, ok)
+	return ok
+}
+
+// run calls the job function, turning a panic into a *PanicError.
+func (p *Pool[J, R]) run(job J) (res Result[J, R]) {
+	res.Job = job
+	defer func() {
+		if value := recover(); value != nil {
+			res.Err = &PanicError{Value: value, Stack: debug.Stack()}
+		}
+	}()
+	res.Value, res.Err = p.fn(p.ctx, job)
+	return res
+}
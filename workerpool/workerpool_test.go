@@ -0,0 +1,257 @@
+package workerpool
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fakhir/learngo/internal/leaktest"
+)
+
+func square(ctx context.Context, x int) (int, error) {
+	return x * x, nil
+}
+
+func TestPool(t *testing.T) {
+	leaktest.Check(t)
+	ctx := context.Background()
+	pool := New(ctx, 4, 2, square)
+	go func() {
+		for i := 0; i < 100; i++ {
+			if err := pool.Submit(ctx, i); err != nil {
+				t.Error(err)
+			}
+		}
+		pool.Close()
+	}()
+
+	var got []int
+	for res := range pool.Results() {
+		if res.Err != nil || res.Value != res.Job*res.Job {
+			t.Errorf("unexpected result %+v", res)
+		}
+		got = append(got, res.Job)
+	}
+	sort.Ints(got)
+	if len(got) != 100 || got[0] != 0 || got[99] != 99 {
+		t.Errorf("got results for jobs %v", got)
+	}
+	if err := pool.Submit(ctx, 1); !errors.Is(err, ErrClosed) {
+		t.Errorf("Submit after Close: got %v", err)
+	}
+}
+
+func TestErrorsAndPanics(t *testing.T) {
+	leaktest.Check(t)
+	errOdd := errors.New("odd")
+	pool := New(context.Background(), 2, 0, func(ctx context.Context, x int) (int, error) {
+		if x == 3 {
+			var m map[string]int
+			m["x"] = x // panics
+		}
+		if x%2 == 1 {
+			return 0, errOdd
+		}
+		return x, nil
+	})
+	go func() {
+		for i := 0; i < 4; i++ {
+			pool.Submit(context.Background(), i)
+		}
+		pool.Close()
+	}()
+
+	for res := range pool.Results() {
+		var perr *PanicError
+		switch {
+		case res.Job == 3:
+			if !errors.As(res.Err, &perr) || !strings.Contains(string(perr.Stack), "workerpool_test.go") {
+				t.Errorf("job 3: got %v, want a *PanicError", res.Err)
+			}
+		case res.Job%2 == 1:
+			if res.Err != errOdd {
+				t.Errorf("job %d: got %v, want %v", res.Job, res.Err, errOdd)
+			}
+		case res.Err != nil:
+			t.Errorf("job %d: %v", res.Job, res.Err)
+		}
+	}
+}
+
+// TestBoundedQueue checks that Submit blocks while the queue is full.
+func TestBoundedQueue(t *testing.T) {
+	leaktest.Check(t)
+	started := make(chan int, 3)
+	release := make(chan bool)
+	pool := New(context.Background(), 1, 1, func(ctx context.Context, x int) (int, error) {
+		started <- x
+		<-release
+		return x, nil
+	})
+	ctx := context.Background()
+	pool.Submit(ctx, 1)
+	<-started           // the worker is busy with the first job
+	pool.Submit(ctx, 2) // and the second one fills the queue
+
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := pool.Submit(timeout, 3); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Submit to a full queue: got %v", err)
+	}
+
+	close(release)
+	go pool.Close()
+	n := 0
+	for range pool.Results() {
+		n++
+	}
+	if n != 2 {
+		t.Errorf("got %d results, want 2", n)
+	}
+}
+
+// TestCloseDuringSubmit checks that Close releases a call to Submit waiting
+// for room in the queue, as when the consumer of the results gives up.
+func TestCloseDuringSubmit(t *testing.T) {
+	leaktest.Check(t)
+	release := make(chan bool)
+	pool := New(context.Background(), 1, 0, func(ctx context.Context, x int) (int, error) {
+		<-release
+		return x, nil
+	})
+	ctx := context.Background()
+	if err := pool.Submit(ctx, 1); err != nil { // taken by the worker
+		t.Fatal(err)
+	}
+	submitted := make(chan error)
+	go func() {
+		submitted <- pool.Submit(ctx, 2) // waits, since no worker is free
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	closed := make(chan bool)
+	go func() {
+		pool.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close waits for the blocked Submit")
+	}
+	if err := <-submitted; !errors.Is(err, ErrClosed) {
+		t.Errorf("blocked Submit: got %v, want %v", err, ErrClosed)
+	}
+
+	close(release)
+	n := 0
+	for range pool.Results() {
+		n++
+	}
+	if n != 1 {
+		t.Errorf("got %d results, want 1", n)
+	}
+}
+
+// TestCancel checks that cancelling the context stops the workers even if
+// nobody receives the results.
+func TestCancel(t *testing.T) {
+	leaktest.Check(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	var started atomic.Int32
+	pool := New(ctx, 3, 10, func(ctx context.Context, x int) (int, error) {
+		started.Add(1)
+		<-ctx.Done()
+		return 0, ctx.Err()
+	})
+	for i := 0; i < 10; i++ {
+		if err := pool.Submit(ctx, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for started.Load() < 3 {
+		runtime.Gosched()
+	}
+	cancel()
+	pool.Wait()
+
+	if err := pool.Submit(context.Background(), 1); err == nil {
+		t.Error("Submit after cancellation succeeded")
+	}
+	for res := range pool.Results() {
+		if !errors.Is(res.Err, context.Canceled) {
+			t.Errorf("unexpected result %+v", res)
+		}
+	}
+}
+
+// TestTrace checks that the hooks of a Trace are called for every worker and
+// every operation on the channels of the pool, with the values sent.
+func TestTrace(t *testing.T) {
+	leaktest.Check(t)
+	var mu sync.Mutex
+	names := make(map[any]string)
+	var workers, closes int
+	ops := make(map[string][]int) // the values of the operations, by kind and channel
+	op := func(kind string) func(ch any) func(v any, ok bool) {
+		return func(ch any) func(v any, ok bool) {
+			return func(v any, ok bool) {
+				mu.Lock()
+				defer mu.Unlock()
+				if ok {
+					key := kind + " " + names[ch]
+					ops[key] = append(ops[key], v.(int))
+				}
+			}
+		}
+	}
+	ctx := WithTrace(context.Background(), &Trace{
+		Channel: func(ch any, name string) { names[ch] = name },
+		WorkerStart: func() {
+			mu.Lock()
+			defer mu.Unlock()
+			workers++
+		},
+		Send: op("send"),
+		Recv: op("recv"),
+		Close: func(ch any) {
+			mu.Lock()
+			defer mu.Unlock()
+			if names[ch] == "queue" {
+				closes++
+			}
+		},
+	})
+	pool := New(ctx, 4, 2, square)
+	if names[pool.Results()] != "results" {
+		t.Errorf("the results channel is named %q", names[pool.Results()])
+	}
+	go func() {
+		for i := 0; i < 20; i++ {
+			pool.Submit(ctx, i)
+		}
+		pool.Close()
+	}()
+	for range pool.Results() {
+	}
+	pool.Wait() // for the call to Close to end
+
+	if workers != 4 || closes != 1 {
+		t.Errorf("got %d workers started and %d closes of the queue, want 4 and 1", workers, closes)
+	}
+	for key, want := range map[string]int{"send queue": 190, "recv queue": 190, "send results": 2470} {
+		sum := 0
+		for _, v := range ops[key] {
+			sum += v
+		}
+		if len(ops[key]) != 20 || sum != want {
+			t.Errorf("%s: got %v", key, ops[key])
+		}
+	}
+}